@@ -0,0 +1,111 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+type (
+	// codeTokenizer splits source code into identifiers. Each identifier is kept whole and, when it is
+	// camelCase or snake_case, its parts are added too: "parseJson_v2" gives parsejson_v2, parse, json
+	// and v2. Everything is lowercased; single characters and the edition's stopwords are dropped.
+	codeTokenizer struct {
+		ed *edition
+	}
+)
+
+// htmlCodeBlocks returns the text of the <pre> and <code> elements in s, without the markup inside
+// them such as syntax highlighting spans. A <code> inside a <pre> is part of the pre's block.
+func htmlCodeBlocks(s string) []string {
+	var (
+		blocks []string
+		cur    strings.Builder
+		depth  int
+	)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			break
+		}
+		if depth > 0 {
+			cur.WriteString(s[:i])
+		}
+		s = s[i:]
+		j := strings.IndexByte(s, '>')
+		if j < 0 {
+			break
+		}
+		tag, ok := parseHtmlTag(s[1:j])
+		s = s[j+1:]
+		switch {
+		case tag.name == "br" && depth > 0:
+			cur.WriteByte('\n')
+		case !ok || tag.selfEnd:
+		case tag.name != "pre" && tag.name != "code":
+		case !tag.end:
+			depth++
+		case depth > 0:
+			if depth--; depth == 0 {
+				blocks = append(blocks, html.UnescapeString(cur.String()))
+				cur.Reset()
+			}
+		}
+	}
+	return blocks
+}
+
+func (c codeTokenizer) Tokenize(text string) []token {
+	var tkns []token
+	add := func(w string) {
+		if w = strings.ToLower(w); len([]rune(w)) > 1 && !c.ed.stopWords[w] {
+			tkns = append(tkns, token{Text: w, Pos: len(tkns)})
+		}
+	}
+	rs := []rune(text)
+	for i := 0; i < len(rs); {
+		if !isIdentRune(rs[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(rs) && isIdentRune(rs[j]) {
+			j++
+		}
+		if id := string(rs[i:j]); !unicode.IsDigit(rs[i]) {
+			add(id)
+			if parts := identifierParts(id); len(parts) > 1 {
+				for _, p := range parts {
+					add(p)
+				}
+			}
+		}
+		i = j
+	}
+	return tkns
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// identifierParts splits an identifier at underscores and camelCase humps, keeping acronyms
+// and digits with their word: "HTTPServer_v2" gives HTTP, Server, v2
+func identifierParts(id string) []string {
+	var parts []string
+	for _, chunk := range strings.Split(id, "_") {
+		rs := []rune(chunk)
+		start := 0
+		for i := 1; i < len(rs); i++ {
+			if unicode.IsUpper(rs[i]) && (unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1]) ||
+				(unicode.IsUpper(rs[i-1]) && i+1 < len(rs) && unicode.IsLower(rs[i+1]))) {
+				parts = append(parts, string(rs[start:i]))
+				start = i
+			}
+		}
+		if start < len(rs) {
+			parts = append(parts, string(rs[start:]))
+		}
+	}
+	return parts
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHtmlCodeBlocks(t *testing.T) {
+	tests := []struct {
+		html string
+		want []string
+	}{
+		{`<p>see <code>x &lt; y</code></p>`, []string{"x < y"}},
+		{`<pre><code><span class="k">func</span> main()</code></pre>`, []string{"func main()"}},
+		{`<pre>a := 1<br>b := 2<br/>c := 3<br />d := 4</pre>`, []string{"a := 1\nb := 2\nc := 3\nd := 4"}},
+		{`<p>one<br/>two</p>`, nil},
+	}
+	for _, tt := range tests {
+		if got := htmlCodeBlocks(tt.html); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("htmlCodeBlocks(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestIdentifierParts(t *testing.T) {
+	tests := map[string][]string{
+		"parseJson_v2":  {"parse", "Json", "v2"},
+		"HTTPServer_v2": {"HTTP", "Server", "v2"},
+		"snake_case":    {"snake", "case"},
+		"plain":         {"plain"},
+	}
+	for id, want := range tests {
+		if got := identifierParts(id); !reflect.DeepEqual(got, want) {
+			t.Errorf("identifierParts(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
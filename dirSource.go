@@ -0,0 +1,249 @@
+package main
+
+import (
+	"html"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// dirSource walks a documentation tree and serves its html and markdown files as pages.
+	// The relative path without extension is the page location, so "guide/install.md" becomes
+	// a child of "guide/index.md" (or "guide/README.md") through the usual parent lookup.
+	// Files mapping onto the same location, like "guide.md" and "guide/index.md", would be one
+	// page; the first in walk order keeps it and the others are quarantined.
+	dirSource struct {
+		root    string
+		baseUrl string
+		files   []dirEntry
+		i       int
+	}
+	dirEntry struct {
+		url   string
+		path  string
+		rel   string // path below root, with forward slashes
+		dupOf string // rel of the file that keeps this file's page location
+	}
+)
+
+var (
+	dirIndexNames = map[string]bool{"index": true, "readme": true, "_index": true}
+	dirFileKinds  = map[string]string{".html": "html", ".htm": "html", ".md": "markdown", ".markdown": "markdown"}
+
+	mdFrontMatterRe = regexp.MustCompile(`(?s)\A---\n.*?\n---\n`)
+	mdFenceRe       = regexp.MustCompile("(?m)^\\s*(```|~~~).*$")
+	mdImageRe       = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkRe        = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	mdRefDefRe      = regexp.MustCompile(`(?m)^\s*\[[^\]]+\]:\s+\S+.*$`)
+	mdHeadingRe     = regexp.MustCompile(`(?m)^\s{0,3}#{1,6}\s*(.*?)\s*#*\s*$`)
+	mdSetextRe      = regexp.MustCompile(`(?m)^\s*(=+|-+)\s*$`)
+	mdBlockRe       = regexp.MustCompile(`(?m)^\s*(>\s*)+|^\s*([*+-]|\d+[.)])\s+`)
+	mdCodeSpanRe    = regexp.MustCompile("``(.+?)``|`([^`\n]+)`")
+	mdEmphasisRe    = regexp.MustCompile(`[*_~]+`)
+	htmlTitleRe     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>|<h1[^>]*>(.*?)</h1>`)
+)
+
+func newDirSource(root, baseUrl string) (*dirSource, error) {
+	s := &dirSource{root: root, baseUrl: baseUrl}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := dirFileKinds[strings.ToLower(filepath.Ext(p))]; !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		s.files = append(s.files, dirEntry{url: baseUrl + dirPageLoc(rel), path: p, rel: filepath.ToSlash(rel)})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "walking document directory")
+	}
+	sort.SliceStable(s.files, func(i, j int) bool { return s.files[i].url < s.files[j].url })
+	for i := 1; i < len(s.files); i++ {
+		if prev := s.files[i-1]; prev.url == s.files[i].url {
+			s.files[i].dupOf = prev.rel
+			if prev.dupOf != "" {
+				s.files[i].dupOf = prev.dupOf
+			}
+		}
+	}
+	return s, nil
+}
+
+// dirPageLoc turns a relative file path into a page location, mapping index files onto their directory
+func dirPageLoc(rel string) string {
+	rel = filepath.ToSlash(rel)
+	loc := strings.TrimSuffix(rel, path.Ext(rel))
+	if dirIndexNames[strings.ToLower(path.Base(loc))] {
+		if dir := path.Dir(loc); dir != "." {
+			return dir
+		}
+	}
+	return loc
+}
+
+// dirLinkLoc maps the location a link points at onto the page location of the file it names,
+// e.g. "guide/install.md" or "guide/index.html" onto "guide/install" and "guide"
+func dirLinkLoc(loc string) string {
+	loc = strings.TrimSuffix(loc, "/")
+	if _, ok := dirFileKinds[strings.ToLower(path.Ext(loc))]; ok {
+		return dirPageLoc(loc)
+	}
+	return loc
+}
+
+func (s *dirSource) Next() (sourceRecord, error) {
+	var rec sourceRecord
+	if s.i >= len(s.files) {
+		return rec, io.EOF
+	}
+	f := s.files[s.i]
+	s.i++
+	if f.dupOf != "" {
+		return rec, &rowError{Url: f.url, Reason: reasonDuplicateUrl, err: errors.Errorf("%s maps onto the same page as %s", f.rel, f.dupOf)}
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return rec, newRowError(f.url, errors.Wrapf(err, "reading %s", f.path))
+	}
+	content := string(b)
+	rec.Url = f.url
+	rec.LinkBase = s.baseUrl + f.rel
+	rec.Title = path.Base(strings.TrimPrefix(f.url, s.baseUrl))
+
+	if dirFileKinds[strings.ToLower(filepath.Ext(f.path))] == "html" {
+		rec.BodyHtml = content
+		rec.BodyText = strings.Join(htmlTextBlocks(content), "\n")
+		if m := htmlTitleRe.FindStringSubmatch(content); m != nil {
+			if t := strings.Join(htmlTextBlocks(m[1]+m[2]), " "); t != "" {
+				rec.Title = t
+			}
+		}
+	} else {
+		content = mdFrontMatterRe.ReplaceAllString(content, "")
+		if m := mdHeadingRe.FindStringSubmatch(content); m != nil && m[1] != "" {
+			rec.Title = stripMarkdown(m[1])
+		}
+		rec.BodyText = stripMarkdown(content)
+	}
+	rec.Abstract = firstParagraph(rec.BodyText)
+	if info, err := os.Stat(f.path); err == nil {
+		rec.Modified = info.ModTime().UTC().Format("2006-01-02T15:04:05Z")
+	}
+	return rec, nil
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
+
+// stripMarkdown reduces markdown to its readable text. The contents of fenced code blocks and code
+// spans are kept verbatim, so identifiers like snake_case survive.
+func stripMarkdown(s string) string {
+	var (
+		b           strings.Builder
+		prose, code []string
+		fence       string
+	)
+	flushProse := func() {
+		if len(prose) > 0 {
+			b.WriteString(stripMarkdownProse(strings.Join(prose, "\n")))
+			b.WriteString("\n")
+			prose = prose[:0]
+		}
+	}
+	for _, line := range strings.Split(s, "\n") {
+		marker := ""
+		if mdFenceRe.MatchString(line) {
+			marker = strings.TrimSpace(line)[:3]
+		}
+		switch {
+		case fence == "" && marker != "":
+			flushProse()
+			fence = marker
+		case fence != "" && marker == fence:
+			fence = ""
+			for _, c := range code {
+				b.WriteString(c)
+				b.WriteString("\n")
+			}
+			code = code[:0]
+		case fence != "":
+			code = append(code, line)
+		default:
+			prose = append(prose, line)
+		}
+	}
+	// an unclosed fence runs to the end of the document
+	for _, c := range code {
+		b.WriteString(c)
+		b.WriteString("\n")
+	}
+	flushProse()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// stripMarkdownProse strips the markup of text outside fenced code blocks, keeping code spans verbatim
+func stripMarkdownProse(s string) string {
+	s = mdImageRe.ReplaceAllString(s, "$1")
+	s = mdLinkRe.ReplaceAllString(s, "$1")
+	s = mdRefDefRe.ReplaceAllString(s, "")
+	s = mdHeadingRe.ReplaceAllString(s, "$1")
+	s = mdSetextRe.ReplaceAllString(s, "")
+	s = mdBlockRe.ReplaceAllString(s, "")
+
+	var b strings.Builder
+	last := 0
+	for _, m := range mdCodeSpanRe.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(stripInlineMarkup(s[last:m[0]]))
+		if m[2] >= 0 {
+			b.WriteString(s[m[2]:m[3]])
+		} else {
+			b.WriteString(s[m[4]:m[5]])
+		}
+		last = m[1]
+	}
+	b.WriteString(stripInlineMarkup(s[last:]))
+	return b.String()
+}
+
+// stripInlineMarkup drops html tags and the emphasis delimiters at word boundaries; delimiters
+// inside a word, like the underscores of snake_case, stay
+func stripInlineMarkup(s string) string {
+	s = wikiTagRe.ReplaceAllString(s, "")
+	var b strings.Builder
+	last := 0
+	for _, m := range mdEmphasisRe.FindAllStringIndex(s, -1) {
+		b.WriteString(s[last:m[0]])
+		if m[0] > 0 && m[1] < len(s) && isWordByte(s[m[0]-1]) && isWordByte(s[m[1]]) {
+			b.WriteString(s[m[0]:m[1]])
+		}
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return html.UnescapeString(b.String())
+}
+
+// isWordByte reports whether b is a letter or digit, or part of a multibyte character
+func isWordByte(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') || b >= 0x80
+}
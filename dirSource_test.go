@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDirPageLoc(t *testing.T) {
+	tests := map[string]string{
+		"guide/install.md":     "guide/install",
+		"guide/index.md":       "guide",
+		"guide/README.md":      "guide",
+		"guide/_index.html":    "guide",
+		"index.html":           "index",
+		"api/v1.2/client.html": "api/v1.2/client",
+		"notes.markdown":       "notes",
+	}
+	for rel, want := range tests {
+		if got := dirPageLoc(filepath.FromSlash(rel)); got != want {
+			t.Errorf("dirPageLoc(%q) = %q, want %q", rel, got, want)
+		}
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"guide.md":           "# Guide\n\nOutside the directory.",
+		"guide/index.md":     "---\ntitle: x\n---\n# The Guide\n\nStart [here](install.md).\n\nMore.",
+		"guide/readme.md":    "# Readme",
+		"guide/install.html": "<html><title>Install</title><p>Run <code>make</code>.</p></html>",
+		"guide/notes.txt":    "not a page",
+		".hidden/secret.md":  "# Secret",
+		"reference/api.md":   "Plain text without a heading.",
+	}
+	for rel, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := "https://docs.example.com/"
+	src, err := newDirSource(root, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		titles     = make(map[string]string)
+		duplicates []string
+	)
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		var re *rowError
+		if errors.As(err, &re) {
+			if re.Reason != reasonDuplicateUrl || re.Url != base+"guide" {
+				t.Errorf("unexpected row error %+v", re)
+			}
+			duplicates = append(duplicates, re.Error())
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles[rec.Url] = rec.Title
+		if rec.Url == base+"guide" && (!strings.Contains(rec.BodyText, "Start here.") || rec.LinkBase != base+"guide/index.md") {
+			t.Errorf("guide page read as %+v", rec)
+		}
+	}
+	wantTitles := map[string]string{
+		base + "guide":         "The Guide",
+		base + "guide/install": "Install",
+		base + "reference/api": "api",
+	}
+	if !reflect.DeepEqual(titles, wantTitles) {
+		t.Errorf("read pages %v, want %v", titles, wantTitles)
+	}
+	wantDuplicates := []string{
+		"guide/readme.md maps onto the same page as guide/index.md",
+		"guide.md maps onto the same page as guide/index.md",
+	}
+	if !reflect.DeepEqual(duplicates, wantDuplicates) {
+		t.Errorf("quarantined %q, want %q", duplicates, wantDuplicates)
+	}
+}
+
+func TestStripMarkdown(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"call parse_page_loc first", "call parse_page_loc first"},
+		{"a **bold** and _em_ word", "a bold and em word"},
+		{"__strong__ ~~gone~~ *x*", "strong gone x"},
+		{"use `snake_case *ptr` here", "use snake_case *ptr here"},
+		{"# Title\n\n- item one", "Title\nitem one"},
+		{"see [the docs](a.md)", "see the docs"},
+		{"text\n```go\n# not a heading\n- not_a_list **x**\n```\nafter _it_", "text\n# not a heading\n- not_a_list **x**\nafter it"},
+		{"~~~\nopen_fence\n", "open_fence\n"},
+		{"fish &amp; chips", "fish & chips"},
+	} {
+		if got := stripMarkdown(c.in); got != c.want {
+			t.Errorf("stripMarkdown(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
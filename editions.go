@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// edition is one wiki site ingested by a run, e.g. the English or German wikibooks.
+	// Page paths and token vocabularies are keyed by edition so editions never collide.
+	edition struct {
+		Lang           string
+		BaseUrl        string // page urls are BaseUrl + page location, e.g. https://de.wikibooks.org/wiki/
+		Site           string // host of BaseUrl
+		Source         string // ETL_SOURCE kind, see openSource
+		DictionaryPath string
+		dictionary     map[string]bool
+		stopWords      map[string]bool
+		vocabulary     string // vocabDictionary, vocabCorpus or vocabMerged
+		tokenizer      Tokenizer
+		apostrophes    string       // TOKEN_APOSTROPHES, how the unicode splitter treats apostrophes inside words
+		hyphens        string       // TOKEN_HYPHENS, likewise for hyphens
+		sqlite         sqliteConfig // column mapping of a sqlite source
+		db             *sql.DB      // sqlite export opened and validated at startup
+	}
+)
+
+var (
+	editions       []*edition
+	editionsByLang = make(map[string]*edition)
+	editionsBySite = make(map[string]*edition)
+)
+
+// loadEditions reads WIKI_EDITIONS, a comma separated list of language codes defaulting to "en".
+// Each edition defaults to https://<lang>.wikibooks.org/wiki/ and the dictionary file ./<lang>;
+// see editionEnv for overriding them.
+func loadEditions() {
+	for _, lang := range strings.Split(envOr("WIKI_EDITIONS", "en"), ",") {
+		if lang = strings.TrimSpace(lang); lang == "" {
+			continue
+		}
+		if _, dup := editionsByLang[lang]; dup {
+			log.Fatalf("edition %s is listed twice in WIKI_EDITIONS", lang)
+		}
+		ed, err := newEdition(lang)
+		if err != nil {
+			err = errors.Wrapf(err, "configuring edition %s", lang)
+			log.Fatal(err)
+		}
+		if other, dup := editionsBySite[ed.Site]; dup {
+			log.Fatalf("editions %s and %s share the site %s", other.Lang, lang, ed.Site)
+		}
+		editions = append(editions, ed)
+		editionsByLang[lang] = ed
+		editionsBySite[ed.Site] = ed
+	}
+	if len(editions) == 0 {
+		log.Fatal("WIKI_EDITIONS lists no editions")
+	}
+	if err := validateTextSource(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateMissingParents(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadFieldWeights(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadLangProfiles(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newEdition(lang string) (*edition, error) {
+	ed := &edition{
+		Lang:           lang,
+		BaseUrl:        editionEnv("WIKI_BASE_URL", lang, "https://"+lang+".wikibooks.org/wiki/"),
+		Source:         editionEnv("ETL_SOURCE", lang, "sqlite"),
+		DictionaryPath: editionEnv("DICTIONARY_PATH", lang, workDir+lang),
+	}
+	if !strings.HasSuffix(ed.BaseUrl, "/") {
+		ed.BaseUrl += "/"
+	}
+	u, err := url.Parse(ed.BaseUrl)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("base url %q is not an absolute url", ed.BaseUrl)
+	}
+	ed.Site = strings.ToLower(u.Hostname())
+	ed.vocabulary = editionEnv("VOCABULARY", lang, vocabDictionary)
+	if err = validVocabulary(ed.vocabulary); err != nil {
+		return nil, err
+	}
+	if err = ed.loadStopWords(); err != nil {
+		return nil, err
+	}
+	if ed.apostrophes, err = joinerMode("TOKEN_APOSTROPHES", lang); err != nil {
+		return nil, err
+	}
+	if ed.hyphens, err = joinerMode("TOKEN_HYPHENS", lang); err != nil {
+		return nil, err
+	}
+	if ed.tokenizer, err = newTokenChain(ed, editionEnv("TOKENIZER", lang, defaultTokenChain)); err != nil {
+		return nil, errors.Wrap(err, "building tokenizer")
+	}
+	if ed.Source == "sqlite" {
+		if ed.sqlite, err = sqliteConfigFromEnv(lang); err != nil {
+			return nil, err
+		}
+		if ed.db, err = openSqlite(ed.sqlite); err != nil {
+			return nil, err
+		}
+	}
+	return ed, nil
+}
+
+// editionEnv reads key for one edition: KEY_<LANG> wins, the bare KEY is the default for every
+// edition, and def is used otherwise. A bare key that must differ per edition, such as WIKI_BASE_URL,
+// fails at startup when several editions end up sharing it.
+func editionEnv(key, lang, def string) string {
+	if v := os.Getenv(key + "_" + strings.ToUpper(lang)); v != "" {
+		return v
+	}
+	return envOr(key, def)
+}
+
+// defaultStopWords holds the stopword lists shipped with the binary, one stopwords/<lang>.txt per language
+//
+//go:embed stopwords
+var defaultStopWords embed.FS
+
+// loadStopWords reads the edition's stopword list from STOPWORDS_PATH, or the shipped list for its
+// language, then applies the comma separated STOPWORDS_ADD and STOPWORDS_REMOVE for this run
+func (ed *edition) loadStopWords() error {
+	var (
+		b   []byte
+		err error
+	)
+	if path := editionEnv("STOPWORDS_PATH", ed.Lang, ""); path != "" {
+		if b, err = os.ReadFile(path); err != nil {
+			return errors.Wrapf(err, "reading stopword file %s", path)
+		}
+	} else if b, err = defaultStopWords.ReadFile("stopwords/" + ed.Lang + ".txt"); err != nil {
+		log.Printf("no stopword list for %s, keeping all dictionary words", ed.Lang)
+	}
+	ed.stopWords = make(map[string]bool)
+	for _, line := range strings.Split(string(b), "\n") {
+		if w := strings.ToLower(strings.TrimSpace(line)); w != "" && !strings.HasPrefix(w, "#") {
+			ed.stopWords[w] = true
+		}
+	}
+	for _, w := range strings.Split(editionEnv("STOPWORDS_ADD", ed.Lang, ""), ",") {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			ed.stopWords[w] = true
+		}
+	}
+	for _, w := range strings.Split(editionEnv("STOPWORDS_REMOVE", ed.Lang, ""), ",") {
+		delete(ed.stopWords, strings.ToLower(strings.TrimSpace(w)))
+	}
+	return nil
+}
+
+func (ed *edition) loadDictionary() {
+	dictb, err := os.ReadFile(ed.DictionaryPath)
+	if err != nil {
+		err = errors.Wrapf(err, "opening and reading %s dictionary file", ed.Lang)
+		log.Fatal(err)
+	}
+	dictArr := strings.Split(strings.ToLower(string(dictb)), "\n")
+	ed.dictionary = make(map[string]bool, len(dictArr))
+	for _, v := range dictArr {
+		ed.dictionary[strings.TrimSpace(v)] = true
+	}
+}
+
+// pageLoc returns the page location of a url below the edition's base, e.g. "Book/Chapter"
+func (ed *edition) pageLoc(pageUrl string) string {
+	return strings.TrimPrefix(pageUrl, ed.BaseUrl)
+}
+
+// pathKey qualifies a page location with the edition's site for allWikibooksByPath
+func (ed *edition) pathKey(pageLoc string) string {
+	return ed.Site + "/" + pageLoc
+}
+
+// tokenKey qualifies a token with its language so vocabularies of different editions stay apart
+func tokenKey(lang, tkn string) string {
+	return lang + ":" + tkn
+}
+
+func splitTokenKey(key string) (lang, tkn string) {
+	i := strings.IndexByte(key, ':')
+	return key[:i], key[i+1:]
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditionEnv(t *testing.T) {
+	t.Setenv("WIKI_EDITIONS", "en,de")
+	t.Setenv("VOCABULARY", "merged")
+	t.Setenv("VOCABULARY_DE", "corpus")
+
+	if got := editionEnv("VOCABULARY", "en", vocabDictionary); got != "merged" {
+		t.Errorf("en got %q, want the bare key's merged", got)
+	}
+	if got := editionEnv("VOCABULARY", "de", vocabDictionary); got != "corpus" {
+		t.Errorf("de got %q, want its own corpus", got)
+	}
+	if got := editionEnv("TOKENIZER", "de", defaultTokenChain); got != defaultTokenChain {
+		t.Errorf("unset key got %q, want the default", got)
+	}
+}
+
+// testEdition configures an edition the way loadEditions does, without a source of its own, and
+// registers it as the only edition
+func testEdition(t *testing.T, lang string) *edition {
+	t.Helper()
+	t.Setenv("ETL_SOURCE", "none")
+	ed, err := newEdition(lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed.loadDictionary()
+	editions = []*edition{ed}
+	editionsByLang = map[string]*edition{lang: ed}
+	editionsBySite = map[string]*edition{ed.Site: ed}
+	return ed
+}
+
+func TestLoadStopWords(t *testing.T) {
+	custom := filepath.Join(t.TempDir(), "stop.txt")
+	if err := os.WriteFile(custom, []byte("# comment\nFoo\n  bar \n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, lang string
+		env        map[string]string
+		stop, keep []string
+		err        bool
+	}{
+		{name: "shipped list", lang: "en", stop: []string{"the", "and", "keep", "sure"},
+			keep: []string{"research", "information", "keepkeeps", "suret", "# english stopwords"}},
+		{name: "file", lang: "en", env: map[string]string{"STOPWORDS_PATH": custom},
+			stop: []string{"foo", "bar"}, keep: []string{"the", "# comment"}},
+		{name: "additions and removals", lang: "en", env: map[string]string{"STOPWORDS_ADD": "Wiki, book", "STOPWORDS_REMOVE_EN": "the,And"},
+			stop: []string{"wiki", "book", "or"}, keep: []string{"the", "and"}},
+		{name: "no list for the language", lang: "xx", env: map[string]string{"STOPWORDS_ADD": "und"},
+			stop: []string{"und"}, keep: []string{"the", "and"}},
+		{name: "missing file", lang: "en", env: map[string]string{"STOPWORDS_PATH": custom + ".missing"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			ed := &edition{Lang: tt.lang}
+			if err := ed.loadStopWords(); (err != nil) != tt.err {
+				t.Fatalf("got error %v", err)
+			}
+			for _, w := range tt.stop {
+				if !ed.stopWords[w] {
+					t.Errorf("%q is not a stopword", w)
+				}
+			}
+			for _, w := range tt.keep {
+				if ed.stopWords[w] {
+					t.Errorf("%q is a stopword", w)
+				}
+			}
+		})
+	}
+}
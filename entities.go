@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// entity kinds
+const (
+	entityAcronym = "acronym" // a short all-caps word such as NASA or MP3
+	entityName    = "name"    // a run of capitalized words such as Isaac Newton or Bank of England
+)
+
+type (
+	// entityDoc is a document of the entities collection
+	entityDoc struct {
+		Id         int     `json:"_id" bson:"_id"`
+		Text       string  `json:"text" bson:"text"`
+		Lang       string  `json:"lang" bson:"lang"`
+		Kind       string  `json:"kind" bson:"kind"`
+		References []idQty `json:"references" bson:"references"`
+	}
+	// entityQty is an entity and how often it occurs on a page
+	entityQty struct {
+		Text string `json:"text" bson:"text"`
+		Qty  int    `json:"qty" bson:"qty"`
+	}
+	// entityCount is how often an entity candidate occurs in the corpus, and how often not at the
+	// start of a sentence, where capitalization says nothing; acronyms always count as mid-sentence
+	entityCount struct {
+		total, mid int
+	}
+)
+
+var (
+	// entityExtraction collects entity candidates from each page body before it is lowercased; those
+	// occurring entityMinCount times in the corpus, at least once mid-sentence, become entities
+	entityExtraction = envBool("ENTITIES")
+	entityMinCount   = 3
+
+	entityCounts = make(map[string]entityCount) // corpus counts of candidates by token key
+	// entityConnectors may join the capitalized words of a name
+	entityConnectors = map[string]bool{"of": true, "de": true, "la": true, "von": true, "van": true, "der": true, "da": true, "del": true}
+)
+
+func init() {
+	if n, err := strconv.Atoi(envOr("ENTITY_MIN_COUNT", "")); err == nil && n > 0 {
+		entityMinCount = n
+	}
+}
+
+// extractEntities counts the entity candidates in text by token key. A name run is cut at any
+// punctuation; a leading stopword, capitalized only because it starts a sentence, is dropped.
+func extractEntities(lang, text string) map[string]int {
+	ed := editionsByLang[lang]
+	qty := make(map[string]int)
+	var (
+		run, conn     []string
+		runAtStart    bool
+		sentenceStart = true
+	)
+	add := func(s string, mid bool) {
+		key := tokenKey(lang, s)
+		qty[key]++
+		c := entityCounts[key]
+		c.total++
+		if mid {
+			c.mid++
+		}
+		entityCounts[key] = c
+	}
+	flush := func() {
+		if runAtStart && len(run) > 0 && ed.stopWords[strings.ToLower(run[0])] {
+			run, runAtStart = run[1:], false
+		}
+		for _, w := range run {
+			if !ed.stopWords[strings.ToLower(w)] {
+				add(strings.Join(run, " "), !runAtStart)
+				break
+			}
+		}
+		run, conn = run[:0], conn[:0]
+	}
+
+	for len(text) > 0 {
+		i := strings.IndexFunc(text, isEntityRune)
+		if i < 0 {
+			break
+		}
+		if gap := text[:i]; strings.ContainsAny(gap, ".!?:;\n") {
+			flush()
+			sentenceStart = true
+		} else if strings.TrimSpace(gap) != "" {
+			flush()
+		}
+		text = text[i:]
+		j := strings.IndexFunc(text, func(r rune) bool { return !isEntityRune(r) })
+		if j < 0 {
+			j = len(text)
+		}
+		w := text[:j]
+		text = text[j:]
+
+		switch {
+		case isAcronym(w):
+			flush()
+			add(w, true) // all caps regardless of where the sentence starts
+		case isCapitalized(w):
+			if len(run) == 0 {
+				runAtStart = sentenceStart
+			} else {
+				run = append(run, conn...)
+			}
+			conn = conn[:0]
+			run = append(run, w)
+		case len(run) > 0 && len(conn) == 0 && entityConnectors[w]:
+			conn = append(conn, w)
+		default:
+			flush()
+		}
+		sentenceStart = false
+	}
+	flush()
+	return qty
+}
+
+func isEntityRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isAcronym reports whether w is 2 to 6 characters starting with a capital, with at least two
+// capitals, no lowercase letters and possibly digits: "NASA", "MP3"
+func isAcronym(w string) bool {
+	rs := []rune(w)
+	if len(rs) < 2 || len(rs) > 6 || !unicode.IsUpper(rs[0]) {
+		return false
+	}
+	caps := 0
+	for _, r := range rs {
+		switch {
+		case unicode.IsUpper(r):
+			caps++
+		case !unicode.IsDigit(r):
+			return false
+		}
+	}
+	return caps >= 2
+}
+
+// isCapitalized reports whether w starts with a capital followed by at least one lowercase letter
+func isCapitalized(w string) bool {
+	rs := []rune(w)
+	if len(rs) < 2 || !unicode.IsUpper(rs[0]) {
+		return false
+	}
+	for _, r := range rs[1:] {
+		if unicode.IsLower(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func entityKind(text string) string {
+	if isAcronym(text) {
+		return entityAcronym
+	}
+	return entityName
+}
+
+// admitEntities keeps the staged candidates that pass the thresholds on each page, sorted by text,
+// and returns the admitted entity keys in sorted order for the entities collection
+func admitEntities(ctx context.Context) []string {
+	admitted := admittedEntities()
+	log.Printf("entities: %d of %d candidates admitted", len(admitted), len(entityCounts))
+
+	ok := make(map[string]bool, len(admitted))
+	for _, key := range admitted {
+		ok[key] = true
+	}
+	eachStaged(ctx, func(st *pageStage) {
+		wb := allWikibooksById[st.Id]
+		lang := wb.tokenLang()
+		for _, e := range st.Entities {
+			if ok[tokenKey(lang, e.Text)] {
+				wb.Entities = append(wb.Entities, e)
+			}
+		}
+		sort.Slice(wb.Entities, func(i, j int) bool { return wb.Entities[i].Text < wb.Entities[j].Text })
+	})
+	entityCounts = nil
+	return admitted
+}
+
+// admittedEntities returns the sorted keys of the candidates occurring entityMinCount times, at
+// least once mid-sentence
+func admittedEntities() []string {
+	var admitted []string
+	for key, c := range entityCounts {
+		if c.total >= entityMinCount && c.mid > 0 {
+			admitted = append(admitted, key)
+		}
+	}
+	sort.Strings(admitted)
+	return admitted
+}
+
+// writeEntities inserts the entities collection, numbering entities in the order of keys
+func writeEntities(ctx context.Context, keys []string, wbs []*wikibook) {
+	ids := make(map[string]int, len(keys))
+	docs := make([]*entityDoc, len(keys))
+	for i, key := range keys {
+		lang, text := splitTokenKey(key)
+		ids[key] = i
+		docs[i] = &entityDoc{Id: i, Text: text, Lang: lang, Kind: entityKind(text)}
+	}
+	for _, wb := range wbs {
+		lang := wb.tokenLang()
+		for _, e := range wb.Entities {
+			d := docs[ids[tokenKey(lang, e.Text)]]
+			d.References = append(d.References, idQty{Id: wb.Id, Qty: e.Qty, Fields: fieldQty{Body: e.Qty}})
+		}
+	}
+	for i := 0; i < len(docs); i += writeBatchSize {
+		j := minInt(i+writeBatchSize, len(docs))
+		batch := make([]interface{}, j-i)
+		for k, d := range docs[i:j] {
+			batch[k] = d
+		}
+		if _, err := entityColl.InsertMany(ctx, batch); err != nil {
+			err = errors.Wrap(err, "inserting entities")
+			log.Println(err)
+		}
+	}
+}
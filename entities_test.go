@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractEntities(t *testing.T) {
+	editionsByLang = map[string]*edition{"en": {Lang: "en", stopWords: map[string]bool{"the": true, "we": true, "in": true}}}
+	entityCounts = make(map[string]entityCount)
+
+	got := extractEntities("en", "The Bank of England met NASA. We saw Isaac Newton in Paris, Texas and the Bank of the year.")
+	want := map[string]int{
+		"en:Bank of England": 1, // leading stopword stripped, connector kept
+		"en:NASA":            1,
+		"en:Isaac Newton":    1, // "We" alone is no entity
+		"en:Paris":           1, // the comma cuts the run
+		"en:Texas":           1,
+		"en:Bank":            1, // a connector not followed by a capitalized word is dropped
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	extractEntities("en", "Newton wrote it. MP3 files. A cat.")
+	wantCounts := map[string]entityCount{
+		"en:Newton":          {total: 1, mid: 0}, // sentence start
+		"en:MP3":             {total: 1, mid: 1}, // acronyms always count as mid-sentence
+		"en:Bank of England": {total: 1, mid: 1}, // no longer at the start once the stopword is stripped
+		"en:Isaac Newton":    {total: 1, mid: 1},
+	}
+	for key, want := range wantCounts {
+		if c := entityCounts[key]; c != want {
+			t.Errorf("entityCounts[%s] = %+v, want %+v", key, c, want)
+		}
+	}
+	if _, ok := entityCounts["en:A"]; ok {
+		t.Error("a single capital letter became a candidate")
+	}
+}
+
+func TestAdmittedEntities(t *testing.T) {
+	defer func(n int) { entityMinCount = n }(entityMinCount)
+	entityMinCount = 3
+	entityCounts = map[string]entityCount{
+		"en:Newton":  {total: 3, mid: 1},
+		"en:NASA":    {total: 4, mid: 4},
+		"en:However": {total: 9, mid: 0}, // only ever capitalized at a sentence start
+		"en:Leibniz": {total: 2, mid: 2}, // too rare
+	}
+	if got, want := admittedEntities(), []string{"en:NASA", "en:Newton"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("admitted %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// page fields parseDoc tokenizes separately
+const (
+	fieldTitle = iota
+	fieldAbstract
+	fieldBody
+	fieldCode
+	numFields
+)
+
+type (
+	// fieldQty counts a token's occurrences per page field
+	fieldQty struct {
+		Title    int `bson:"title,omitempty" json:"title,omitempty"`
+		Abstract int `bson:"abstract,omitempty" json:"abstract,omitempty"`
+		Body     int `bson:"body,omitempty" json:"body,omitempty"`
+		Code     int `bson:"code,omitempty" json:"code,omitempty"`
+	}
+)
+
+var (
+	fieldNames = [numFields]string{"title", "abstract", "body", "code"}
+	// fieldWeights multiply each field's counts into the combined count used for Qty, the token
+	// vectors and norms; a weight of 0 leaves the field out. Set with FIELD_WEIGHTS, e.g. "title=3,abstract=2".
+	// The code field is opt-in, since its text is also part of the body, e.g. "code=1".
+	fieldWeights = [numFields]int{1, 1, 1, 0}
+	// fieldVectors adds a sparse vector per field, of unweighted counts, to each token_vector document
+	fieldVectors = envBool("FIELD_VECTORS")
+)
+
+// loadFieldWeights reads FIELD_WEIGHTS, leaving unlisted fields at their default weight
+func loadFieldWeights() error {
+	m, err := parseMapping(envOr("FIELD_WEIGHTS", ""))
+	if err != nil {
+		return errors.Wrap(err, "parsing FIELD_WEIGHTS")
+	}
+	for name, v := range m {
+		f := fieldIndex(name)
+		if f < 0 {
+			return errors.Errorf("FIELD_WEIGHTS: unknown field %q, want title, abstract, body or code", name)
+		}
+		w, err := strconv.Atoi(v)
+		if err != nil || w < 0 {
+			return errors.Errorf("FIELD_WEIGHTS: weight of %s must be a non-negative integer, not %q", name, v)
+		}
+		fieldWeights[f] = w
+	}
+	return nil
+}
+
+func fieldIndex(name string) int {
+	for f, n := range fieldNames {
+		if n == name {
+			return f
+		}
+	}
+	return -1
+}
+
+// fieldTexts returns the text of each field of doc, with the body chosen by docText and the
+// code field made of the code blocks in BodyHtml
+func fieldTexts(doc wikibook) [numFields]string {
+	texts := [numFields]string{doc.Title, doc.Abstract, docText(doc), ""}
+	if fieldWeights[fieldCode] > 0 {
+		texts[fieldCode] = strings.Join(htmlCodeBlocks(doc.BodyHtml), "\n")
+	}
+	return texts
+}
+
+// fieldTokenizer returns the Tokenizer for field f of an edition's pages
+func (ed *edition) fieldTokenizer(f int) Tokenizer {
+	if f == fieldCode {
+		return codeTokenizer{ed}
+	}
+	return ed.tokenizer
+}
+
+func (q *fieldQty) add(f, n int) {
+	switch f {
+	case fieldTitle:
+		q.Title += n
+	case fieldAbstract:
+		q.Abstract += n
+	case fieldBody:
+		q.Body += n
+	case fieldCode:
+		q.Code += n
+	}
+}
+
+func (q fieldQty) get(f int) int {
+	switch f {
+	case fieldTitle:
+		return q.Title
+	case fieldAbstract:
+		return q.Abstract
+	case fieldCode:
+		return q.Code
+	}
+	return q.Body
+}
+
+// weighted is the combined count of a token under fieldWeights
+func (q fieldQty) weighted() int {
+	return q.Title*fieldWeights[fieldTitle] + q.Abstract*fieldWeights[fieldAbstract] +
+		q.Body*fieldWeights[fieldBody] + q.Code*fieldWeights[fieldCode]
+}
+
+// fieldTokenVectors builds the <field>_token_vector elements of wb's token_vector document,
+// keyed by token id like compressed_token_vector, for the fields the page has tokens in
+func fieldTokenVectors(wb *wikibook) bson.D {
+	var vectors [numFields]bson.M
+	for key, q := range wb.fieldQtys {
+		j := strconv.Itoa(tokenIds[key])
+		for f := range vectors {
+			if n := q.get(f); n > 0 {
+				if vectors[f] == nil {
+					vectors[f] = make(bson.M)
+				}
+				vectors[f][j] = n
+			}
+		}
+	}
+	var d bson.D
+	for f, v := range vectors {
+		if v != nil {
+			d = append(d, bson.E{Key: fieldNames[f] + "_token_vector", Value: v})
+		}
+	}
+	return d
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLoadFieldWeights(t *testing.T) {
+	defer func(w [numFields]int) { fieldWeights = w }(fieldWeights)
+	if want := [numFields]int{1, 1, 1, 0}; fieldWeights != want {
+		t.Fatalf("default weights %v, want %v", fieldWeights, want)
+	}
+	if err := loadFieldWeights(); err != nil || fieldWeights != [numFields]int{1, 1, 1, 0} {
+		t.Errorf("unset FIELD_WEIGHTS gave %v, %v, want the defaults", fieldWeights, err)
+	}
+	t.Setenv("FIELD_WEIGHTS", "title=3, code=1")
+	if err := loadFieldWeights(); err != nil || fieldWeights != [numFields]int{3, 1, 1, 1} {
+		t.Errorf("got %v, %v, want [3 1 1 1]", fieldWeights, err)
+	}
+
+	tests := map[string]string{
+		"heading=2": `unknown field "heading"`,
+		"title=-1":  "weight of title must be a non-negative integer",
+		"body=x":    "weight of body must be a non-negative integer",
+		"title":     "malformed mapping entry",
+	}
+	for spec, want := range tests {
+		t.Setenv("FIELD_WEIGHTS", spec)
+		if err := loadFieldWeights(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("FIELD_WEIGHTS=%q gave %v, want an error containing %q", spec, err, want)
+		}
+	}
+}
+
+func TestFieldQtyWeighted(t *testing.T) {
+	defer func(w [numFields]int) { fieldWeights = w }(fieldWeights)
+	q := fieldQty{Title: 1, Abstract: 2, Body: 3, Code: 4}
+	if got := q.weighted(); got != 6 {
+		t.Errorf("default weighted = %d, want 6 since code is left out", got)
+	}
+	fieldWeights = [numFields]int{3, 0, 1, 2}
+	if got := q.weighted(); got != 3+3+8 {
+		t.Errorf("weighted = %d, want 14", got)
+	}
+}
+
+func TestParseDocFields(t *testing.T) {
+	ed := testEdition(t, "en")
+	resetPages()
+	tokenRefs = make(map[string]map[int]bool)
+	wb := parseDoc(wikibook{
+		Id:        7,
+		Lang:      ed.Lang,
+		Title:     "Bread",
+		Abstract:  "Bread and flour",
+		BodyText:  "Bread needs flour, yeast and bread.",
+		tknQtyMap: make(map[string]int),
+		fieldQtys: make(map[string]fieldQty),
+	})
+	allWikibooksById[wb.Id] = &wb
+
+	wantRefs := map[string]idQty{
+		"en:bread": {Id: 7, Qty: 4, Fields: fieldQty{Title: 1, Abstract: 1, Body: 2}},
+		"en:flour": {Id: 7, Qty: 2, Fields: fieldQty{Abstract: 1, Body: 1}},
+		"en:yeast": {Id: 7, Qty: 1, Fields: fieldQty{Body: 1}},
+	}
+	for key, want := range wantRefs {
+		if !tokenRefs[key][7] {
+			t.Errorf("%s does not reference the page", key)
+		}
+		if got := reference(key, 7); got != want {
+			t.Errorf("reference(%s) = %+v, want %+v", key, got, want)
+		}
+	}
+	if wb.CountUniqueWords != 3 { // "needs" and "and" are stopwords
+		t.Errorf("CountUniqueWords = %d, want 3", wb.CountUniqueWords)
+	}
+
+	tokenIds = map[string]int{"en:bread": 0, "en:flour": 1, "en:yeast": 2}
+	want := bson.D{
+		{Key: "title_token_vector", Value: bson.M{"0": 1}},
+		{Key: "abstract_token_vector", Value: bson.M{"0": 1, "1": 1}},
+		{Key: "body_token_vector", Value: bson.M{"0": 2, "1": 1, "2": 1}},
+	}
+	if got := fieldTokenVectors(&wb); !reflect.DeepEqual(got, want) {
+		t.Errorf("fieldTokenVectors = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// noPage is the id of a page that does not exist: the parent of a root page, or the target of a
+// link outside the corpus. Page ids start at 0, so 0 cannot mean "none".
+const noPage = -1
+
+const (
+	parentsAncestor = "ancestor" // link orphans to their nearest existing ancestor
+	parentsVirtual  = "virtual"  // create placeholder pages for missing parents
+	parentsNone     = "none"     // leave orphans without a parent
+)
+
+// missingParents selects how resolveParents treats pages whose direct parent page does not exist
+var missingParents = envOr("MISSING_PARENTS", parentsAncestor)
+
+// validateMissingParents rejects an unknown MISSING_PARENTS value at startup
+func validateMissingParents() error {
+	switch missingParents {
+	case parentsAncestor, parentsVirtual, parentsNone:
+		return nil
+	}
+	return errors.Errorf("MISSING_PARENTS must be %s, %s or %s, not %q", parentsAncestor, parentsVirtual, parentsNone, missingParents)
+}
+
+// parentLoc returns the location of the direct parent of loc, "Book/Part" for "Book/Part/Chapter"
+func parentLoc(loc string) (string, bool) {
+	i := strings.LastIndex(loc, "/")
+	if i < 0 {
+		return "", false
+	}
+	return loc[:i], true
+}
+
+// linkParent records parent as wb's parent page
+func linkParent(wb, parent *wikibook) {
+	wb.parentPage = parent
+	wb.ParentPageId = parent.Id
+	parent.childPages = append(parent.childPages, wb)
+	parent.CountChildren++
+	parent.ChildPageIds = append(parent.ChildPageIds, wb.Id)
+}
+
+// resolveParents runs once every page is registered and links the pages whose parent was not seen
+// before them, either because the source is not in url order or because the parent page is missing.
+// Virtual placeholder parents get ids from nextId and are returned so they can be written.
+func resolveParents(nextId func(pageUrl string) int) []*wikibook {
+	ids := make([]int, 0, len(allWikibooksById))
+	for id := range allWikibooksById {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var virtual []*wikibook
+	for _, id := range ids {
+		wb := allWikibooksById[id]
+		if wb.parentPage != nil {
+			continue
+		}
+		ed := editionsByLang[wb.Lang]
+		loc, ok := parentLoc(ed.pageLoc(wb.Url))
+		if !ok {
+			continue
+		}
+		if parent, ok := allWikibooksByPath[ed.pathKey(loc)]; ok {
+			linkParent(wb, parent)
+			continue
+		}
+		switch missingParents {
+		case parentsAncestor:
+			for ok {
+				if parent, found := allWikibooksByPath[ed.pathKey(loc)]; found {
+					linkParent(wb, parent)
+					break
+				}
+				loc, ok = parentLoc(loc)
+			}
+		case parentsVirtual:
+			linkParent(wb, virtualParent(ed, loc, nextId, &virtual))
+		}
+	}
+	return virtual
+}
+
+// virtualParent returns the page at loc, creating it and any missing ancestors as placeholders
+func virtualParent(ed *edition, loc string, nextId func(pageUrl string) int, virtual *[]*wikibook) *wikibook {
+	if wb, ok := allWikibooksByPath[ed.pathKey(loc)]; ok {
+		return wb
+	}
+	var parent *wikibook
+	if ploc, ok := parentLoc(loc); ok {
+		parent = virtualParent(ed, ploc, nextId, virtual)
+	}
+	pageUrl := ed.BaseUrl + loc
+	id := nextId(pageUrl)
+	vp := &wikibook{
+		Id:           id,
+		CanonicalId:  id,
+		Title:        loc[strings.LastIndex(loc, "/")+1:],
+		Url:          pageUrl,
+		Lang:         ed.Lang,
+		Site:         ed.Site,
+		ParentPageId: noPage,
+		Virtual:      true,
+		tknQtyMap:    make(map[string]int),
+	}
+	allWikibooksByPath[ed.pathKey(loc)] = vp
+	allWikibooksById[vp.Id] = vp
+	if parent != nil {
+		linkParent(vp, parent)
+	}
+	*virtual = append(*virtual, vp)
+	return vp
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestResolveParents(t *testing.T) {
+	ed := &edition{Lang: "en", BaseUrl: "https://en.wikibooks.org/wiki/", Site: "en.wikibooks.org"}
+	editionsByLang = map[string]*edition{"en": ed}
+	defer func(m string) { missingParents = m }(missingParents)
+
+	tests := []struct {
+		name    string
+		mode    string
+		locs    []string          // registered in this order, with ids counting up from 0
+		parents map[string]string // page location to parent location, "" for noPage
+		virtual []string
+	}{
+		{
+			name:    "in order",
+			mode:    parentsAncestor,
+			locs:    []string{"Book", "Book/Part", "Book/Part/Ch"},
+			parents: map[string]string{"Book": "", "Book/Part": "Book", "Book/Part/Ch": "Book/Part"},
+		},
+		{
+			name:    "children before parents",
+			mode:    parentsAncestor,
+			locs:    []string{"Book/Part/Ch", "Book/Part", "Book"},
+			parents: map[string]string{"Book": "", "Book/Part": "Book", "Book/Part/Ch": "Book/Part"},
+		},
+		{
+			name:    "nearest ancestor",
+			mode:    parentsAncestor,
+			locs:    []string{"Book/A/B/Ch", "Book", "Other/Ch"},
+			parents: map[string]string{"Book": "", "Book/A/B/Ch": "Book", "Other/Ch": ""},
+		},
+		{
+			name:    "nested virtual placeholders",
+			mode:    parentsVirtual,
+			locs:    []string{"Book/A/B/Ch", "Book/A/B/Ch2", "Book"},
+			parents: map[string]string{"Book": "", "Book/A": "Book", "Book/A/B": "Book/A", "Book/A/B/Ch": "Book/A/B", "Book/A/B/Ch2": "Book/A/B"},
+			virtual: []string{"Book/A", "Book/A/B"},
+		},
+		{
+			name:    "virtual roots",
+			mode:    parentsVirtual,
+			locs:    []string{"Lost/Ch"},
+			parents: map[string]string{"Lost": "", "Lost/Ch": "Lost"},
+			virtual: []string{"Lost"},
+		},
+		{
+			name:    "none",
+			mode:    parentsNone,
+			locs:    []string{"Book/A/Ch", "Book", "Book/B", "Book/B/Ch"},
+			parents: map[string]string{"Book": "", "Book/A/Ch": "", "Book/B": "Book", "Book/B/Ch": "Book/B"},
+		},
+	}
+	for _, tt := range tests {
+		missingParents = tt.mode
+		resetPages()
+		for id, loc := range tt.locs {
+			registerWikibook(&wikibook{Id: id, Url: ed.BaseUrl + loc, Lang: "en", ParentPageId: noPage})
+		}
+		next := len(tt.locs)
+		virtual := resolveParents(func(string) int {
+			next++
+			return next - 1
+		})
+
+		parents := make(map[string]string)
+		for _, wb := range allWikibooksById {
+			loc, parent := ed.pageLoc(wb.Url), ""
+			if wb.ParentPageId != noPage {
+				p := allWikibooksById[wb.ParentPageId]
+				parent = ed.pageLoc(p.Url)
+				if wb.parentPage != p || !containsId(p.ChildPageIds, wb.Id) || p.CountChildren != len(p.ChildPageIds) {
+					t.Errorf("%s: %s is not among the children of its parent %s", tt.name, loc, parent)
+				}
+			} else if wb.parentPage != nil {
+				t.Errorf("%s: %s has parent page %s but parent id noPage", tt.name, loc, wb.parentPage.Url)
+			}
+			parents[loc] = parent
+		}
+		if !reflect.DeepEqual(parents, tt.parents) {
+			t.Errorf("%s: parents %v, want %v", tt.name, parents, tt.parents)
+		}
+
+		var vlocs []string
+		for _, vp := range virtual {
+			if !vp.Virtual || vp.Id < len(tt.locs) {
+				t.Errorf("%s: placeholder %s is %+v", tt.name, vp.Url, vp)
+			}
+			vlocs = append(vlocs, ed.pageLoc(vp.Url))
+		}
+		sort.Strings(vlocs)
+		if !reflect.DeepEqual(vlocs, tt.virtual) {
+			t.Errorf("%s: placeholders %v, want %v", tt.name, vlocs, tt.virtual)
+		}
+	}
+}
+
+func containsId(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateMissingParents(t *testing.T) {
+	defer func(m string) { missingParents = m }(missingParents)
+	for mode, ok := range map[string]bool{parentsAncestor: true, parentsVirtual: true, parentsNone: true, "ancestors": false, "": false} {
+		missingParents = mode
+		if err := validateMissingParents(); (err == nil) != ok {
+			t.Errorf("MISSING_PARENTS=%q gave %v", mode, err)
+		}
+	}
+}
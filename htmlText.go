@@ -0,0 +1,239 @@
+package main
+
+import (
+	"html"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// text sources, set with TEXT_SOURCE
+const (
+	textBodyText = "body_text" // tokenize the source's body text
+	textHtml     = "html"      // tokenize text extracted from BodyHtml
+)
+
+// textSource selects what parseDoc tokenizes, textBodyText by default
+var textSource = envOr("TEXT_SOURCE", textBodyText)
+
+// validateTextSource rejects an unknown TEXT_SOURCE value at startup
+func validateTextSource() error {
+	if textSource != textBodyText && textSource != textHtml {
+		return errors.Errorf("TEXT_SOURCE must be %s or %s, not %q", textBodyText, textHtml, textSource)
+	}
+	return nil
+}
+
+type (
+	// htmlTag is a single start or end tag found while scanning BodyHtml
+	htmlTag struct {
+		name    string
+		end     bool
+		selfEnd bool
+		attrs   string
+	}
+	// htmlTextExtractor collects readable text blocks from a page's html, skipping page chrome
+	htmlTextExtractor struct {
+		blocks   []string
+		cur      strings.Builder
+		skipTag  string // name of the element whose content is being skipped
+		skipping int    // nesting depth of skipTag while skipping
+	}
+)
+
+var (
+	// htmlDropTags are elements whose entire content is never page text
+	htmlDropTags = map[string]bool{
+		"script": true, "style": true, "noscript": true, "nav": true, "head": true, "template": true,
+	}
+	// htmlDropClasses mark navigation, edit links, references and other mediawiki chrome
+	htmlDropClasses = []string{
+		"mw-editsection", "reference", "references", "reflist", "navbox", "toc", "noprint",
+		"printfooter", "catlinks", "mw-jump-link", "mw-cite-backlink", "navigation",
+	}
+	// htmlBlockTags start and end a separate text block
+	htmlBlockTags = map[string]bool{
+		"p": true, "div": true, "li": true, "dt": true, "dd": true, "td": true, "th": true, "tr": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "caption": true,
+		"pre": true, "blockquote": true, "br": true, "table": true, "ul": true, "ol": true, "dl": true,
+		"section": true, "figcaption": true, "hr": true,
+	}
+	htmlVoidTags = map[string]bool{
+		"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true, "wbr": true,
+		"area": true, "base": true, "col": true, "embed": true, "source": true, "track": true,
+	}
+)
+
+// docText returns the text parseDoc should tokenize for doc according to textSource
+func docText(doc wikibook) string {
+	if textSource == textHtml && doc.BodyHtml != "" {
+		// the space keeps blocks apart under the ascii splitter, which drops newlines
+		return strings.Join(htmlTextBlocks(doc.BodyHtml), " \n")
+	}
+	return doc.BodyText
+}
+
+// htmlTextBlocks walks s and returns its headings, paragraphs, list items, table cells and
+// similar elements as separate text blocks, without script, style, navigation, edit links or references
+func htmlTextBlocks(s string) []string {
+	var e htmlTextExtractor
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			e.text(s)
+			break
+		}
+		e.text(s[:i])
+		s = s[i:]
+
+		if strings.HasPrefix(s, "<!--") {
+			j := strings.Index(s, "-->")
+			if j < 0 {
+				break
+			}
+			s = s[j+3:]
+			continue
+		}
+		j := strings.IndexByte(s, '>')
+		if j < 0 {
+			break
+		}
+		tag, ok := parseHtmlTag(s[1:j])
+		s = s[j+1:]
+		if !ok {
+			continue
+		}
+		if !tag.end && (tag.name == "script" || tag.name == "style") {
+			// raw text elements may contain '<', so jump straight to their end tag
+			k := strings.Index(strings.ToLower(s), "</"+tag.name)
+			if k < 0 {
+				break
+			}
+			s = s[k:]
+			if e.skipping == 0 {
+				continue
+			}
+		}
+		e.tag(tag)
+	}
+	e.endBlock()
+	return e.blocks
+}
+
+func parseHtmlTag(s string) (htmlTag, bool) {
+	var t htmlTag
+	if strings.HasPrefix(s, "/") {
+		t.end = true
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		t.selfEnd = true
+		s = s[:len(s)-1]
+	}
+	i := strings.IndexAny(s, " \t\r\n")
+	if i < 0 {
+		i = len(s)
+	}
+	t.name = strings.ToLower(s[:i])
+	t.attrs = s[i:]
+	if t.name == "" || !isHtmlName(t.name) {
+		return t, false
+	}
+	return t, true
+}
+
+func isHtmlName(s string) bool {
+	for _, r := range s {
+		if !(('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r == '-' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// dropped reports whether the element opened by t is chrome rather than page text
+func (t htmlTag) dropped() bool {
+	if htmlDropTags[t.name] {
+		return true
+	}
+	attrs := strings.ToLower(t.attrs)
+	if strings.Contains(attrs, `role="navigation"`) || strings.Contains(attrs, `id="toc"`) {
+		return true
+	}
+	for _, class := range htmlAttrWords(attrs, "class") {
+		for _, c := range htmlDropClasses {
+			if class == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// htmlAttrWords returns the space separated words of the named attribute
+func htmlAttrWords(attrs, name string) []string {
+	return strings.Fields(htmlAttr(attrs, name))
+}
+
+// htmlAttr returns the value of the named attribute, matching the name case-insensitively
+func htmlAttr(attrs, name string) string {
+	lower := strings.ToLower(attrs)
+	for i := 0; i < len(lower); {
+		j := strings.Index(lower[i:], name+"=")
+		if j < 0 {
+			return ""
+		}
+		j += i
+		if j > 0 && !strings.ContainsRune(" \t\r\n", rune(lower[j-1])) {
+			i = j + 1
+			continue
+		}
+		v := attrs[j+len(name)+1:]
+		if v != "" && (v[0] == '"' || v[0] == '\'') {
+			q := v[0]
+			v = v[1:]
+			if k := strings.IndexByte(v, q); k >= 0 {
+				v = v[:k]
+			}
+		} else if k := strings.IndexAny(v, " \t\r\n"); k >= 0 {
+			v = v[:k]
+		}
+		return html.UnescapeString(v)
+	}
+	return ""
+}
+
+func (e *htmlTextExtractor) tag(t htmlTag) {
+	if e.skipping > 0 {
+		if t.name == e.skipTag && !htmlVoidTags[t.name] && !t.selfEnd {
+			if t.end {
+				e.skipping--
+			} else {
+				e.skipping++
+			}
+		}
+		return
+	}
+	if !t.end && !t.selfEnd && !htmlVoidTags[t.name] && t.dropped() {
+		e.skipTag = t.name
+		e.skipping = 1
+		return
+	}
+	if htmlBlockTags[t.name] {
+		e.endBlock()
+	}
+}
+
+func (e *htmlTextExtractor) text(s string) {
+	if e.skipping > 0 || s == "" {
+		return
+	}
+	e.cur.WriteString(html.UnescapeString(s))
+}
+
+func (e *htmlTextExtractor) endBlock() {
+	if b := strings.Join(strings.Fields(e.cur.String()), " "); b != "" {
+		e.blocks = append(e.blocks, b)
+	}
+	e.cur.Reset()
+}
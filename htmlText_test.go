@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHtmlTextBlocks(t *testing.T) {
+	tests := []struct {
+		html string
+		want []string
+	}{
+		{`<h1>Title</h1><p>First <b>bold</b> para.</p><p>Second</p>`, []string{"Title", "First bold para.", "Second"}},
+		{`<div class="toc">Contents</div><p>Text<span class="mw-editsection">[edit]</span></p>`, []string{"Text"}},
+		{`<script>if (a < b) {}</script><p>After script</p><style>p{}</style>`, []string{"After script"}},
+		{`<ul><li>one</li><li>two<sup class="reference">[1]</sup></li></ul>`, []string{"one", "two"}},
+		{`<p>line<br>break &amp; entity</p><!-- <p>hidden</p> --><nav>menu</nav>`, []string{"line", "break & entity"}},
+		{`<table><tr><td>a</td><td>b</td></tr></table>`, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		if got := htmlTextBlocks(tt.html); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("htmlTextBlocks(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTextSource(t *testing.T) {
+	defer func(s string) { textSource = s }(textSource)
+	for src, ok := range map[string]bool{textBodyText: true, textHtml: true, "bodytext": false, "HTML": false} {
+		textSource = src
+		if err := validateTextSource(); (err == nil) != ok {
+			t.Errorf("TEXT_SOURCE=%q gave %v", src, err)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const watermarkId = "watermark"
+
+type (
+	// incrementalState is what an incremental run knows about previous runs. Page and token ids
+	// stay stable across runs; new pages and tokens are numbered after the highest existing id.
+	incrementalState struct {
+		pages       map[string]pageState // previously written pages by url
+		seen        map[int]bool         // ids of every page present in this run's source
+		added       []int                // ids of pages not written by a previous run
+		changed     []int                // ids of new or modified pages, re-tokenized this run
+		nextPageId  int
+		nextTokenId int
+		watermark   watermark
+	}
+	pageState struct {
+		Id           int    `bson:"_id"`
+		Url          string `bson:"url"`
+		ContentHash  string `bson:"content_hash"`
+		ParentPageId int    `bson:"parent_page"`
+	}
+	// watermark is persisted in etl_state after each run
+	watermark struct {
+		Id       string    `bson:"_id"`
+		Modified string    `bson:"modified"` // highest modified column value seen, compared as a string
+		RunAt    time.Time `bson:"run_at"`
+	}
+)
+
+// contentHash fingerprints the source fields of a page so unchanged pages can be skipped
+func contentHash(rec sourceRecord) string {
+	h := sha256.New()
+	for _, f := range []string{rec.Title, rec.Url, rec.Abstract, rec.BodyText, rec.BodyHtml} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// clearOutputCollections drops the collections a full run rebuilds so InsertMany does not hit duplicate ids.
+// Dropping is opt-in through ETL_DROP_OUTPUT; without it a full run refuses to start over a previous run's output.
+func clearOutputCollections(ctx context.Context, drop bool) {
+	for _, coll := range []*mongo.Collection{wbColl, tokenColl, tokenVectorColl, linkColl, entityColl, postingColl} {
+		if drop {
+			if err := coll.Drop(ctx); err != nil {
+				err = errors.Wrapf(err, "dropping collection %s", coll.Name())
+				log.Fatal(err)
+			}
+			continue
+		}
+		n, err := coll.EstimatedDocumentCount(ctx)
+		if err != nil {
+			err = errors.Wrapf(err, "counting documents in %s", coll.Name())
+			log.Fatal(err)
+		}
+		if n > 0 {
+			log.Fatalf("collection %s holds %d documents from a previous run; set ETL_DROP_OUTPUT=true to replace them or ETL_INCREMENTAL=true to update them",
+				coll.Name(), n)
+		}
+	}
+}
+
+func loadIncrementalState(ctx context.Context) *incrementalState {
+	st := &incrementalState{
+		pages: make(map[string]pageState),
+		seen:  make(map[int]bool),
+	}
+	tokenIds = make(map[string]int)
+
+	err := stateColl.FindOne(ctx, bson.M{"_id": watermarkId}).Decode(&st.watermark)
+	if err != nil && err != mongo.ErrNoDocuments {
+		err = errors.Wrap(err, "loading watermark")
+		log.Fatal(err)
+	}
+
+	cur, err := wbColl.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"url": 1, "content_hash": 1, "parent_page": 1}))
+	if err != nil {
+		err = errors.Wrap(err, "loading previous wikibooks")
+		log.Fatal(err)
+	}
+	for cur.Next(ctx) {
+		var p pageState
+		if err = cur.Decode(&p); err != nil {
+			err = errors.Wrap(err, "decoding previous wikibook")
+			log.Fatal(err)
+		}
+		st.pages[p.Url] = p
+		if p.Id >= st.nextPageId {
+			st.nextPageId = p.Id + 1
+		}
+	}
+	if err = cur.Err(); err != nil {
+		err = errors.Wrap(err, "iterating previous wikibooks")
+		log.Fatal(err)
+	}
+	cur.Close(ctx)
+
+	cur, err = tokenColl.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"token": 1, "lang": 1}))
+	if err != nil {
+		err = errors.Wrap(err, "loading previous tokens")
+		log.Fatal(err)
+	}
+	for cur.Next(ctx) {
+		var t tokenDoc
+		if err = cur.Decode(&t); err != nil {
+			err = errors.Wrap(err, "decoding previous token")
+			log.Fatal(err)
+		}
+		if t.Lang == "" {
+			t.Lang = editions[0].Lang // written before tokens carried a language
+		}
+		tokenIds[tokenKey(t.Lang, t.Token)] = t.Id
+		if t.Id >= st.nextTokenId {
+			st.nextTokenId = t.Id + 1
+		}
+	}
+	if err = cur.Err(); err != nil {
+		err = errors.Wrap(err, "iterating previous tokens")
+		log.Fatal(err)
+	}
+	cur.Close(ctx)
+
+	log.Printf("incremental: %d previous pages, %d previous tokens, watermark %q",
+		len(st.pages), len(tokenIds), st.watermark.Modified)
+	return st
+}
+
+// processRecord re-tokenizes rec if it is new or changed, otherwise only registers it for hierarchy linking.
+// When the source has a modified column, pages at or below the watermark are treated as unchanged without hashing.
+func (st *incrementalState) processRecord(ed *edition, rec sourceRecord) {
+	prev, known := st.pages[rec.Url]
+	id := prev.Id
+	if !known {
+		id = st.nextPageId
+		st.nextPageId++
+		st.added = append(st.added, id)
+	}
+	st.seen[id] = true
+
+	if known {
+		unchanged := rec.Modified != "" && st.watermark.Modified != "" && rec.Modified <= st.watermark.Modified
+		if unchanged || prev.ContentHash == contentHash(rec) {
+			registerStub(ed, id, rec.Url)
+			return
+		}
+	}
+	st.changed = append(st.changed, id)
+	processWikibookRow(id, ed, rec)
+}
+
+// keepQuarantined keeps the previous version of a known page whose row was quarantined, such as on a
+// temporary read error, instead of deleting it as gone from the source
+func (st *incrementalState) keepQuarantined(ed *edition, pageUrl string) {
+	prev, known := st.pages[pageUrl]
+	if !known || st.seen[prev.Id] {
+		return
+	}
+	st.seen[prev.Id] = true
+	registerStub(ed, prev.Id, pageUrl)
+}
+
+// registerStub registers a page kept from the previous run for hierarchy linking only
+func registerStub(ed *edition, id int, pageUrl string) {
+	registerWikibook(&wikibook{Id: id, Url: pageUrl, Lang: ed.Lang, Site: ed.Site, ParentPageId: noPage, CanonicalId: id, stub: true})
+}
+
+// virtualPageId reuses the id of a placeholder parent written by a previous run, or allocates a new one
+func (st *incrementalState) virtualPageId(pageUrl string) int {
+	if prev, ok := st.pages[pageUrl]; ok {
+		st.seen[prev.Id] = true
+		return prev.Id
+	}
+	id := st.nextPageId
+	st.nextPageId++
+	st.added = append(st.added, id)
+	return id
+}
+
+// write updates the tokens and token_vector documents affected by this run and finishes the
+// wikibooks documents the page writer upserted while reading
+func (st *incrementalState) write(ctx context.Context, virtual []*wikibook) {
+	var deleted []int
+	for _, p := range st.pages {
+		if !st.seen[p.Id] {
+			deleted = append(deleted, p.Id)
+		}
+	}
+	sort.Ints(deleted)
+	log.Printf("incremental: %d new, %d changed, %d deleted pages", len(st.added), len(st.changed)-len(st.added), len(deleted))
+
+	for _, v := range allTokens {
+		if _, ok := tokenIds[v]; !ok {
+			tokenIds[v] = st.nextTokenId
+			st.nextTokenId++
+		}
+	}
+
+	st.writeTokens(ctx, append(append([]int{}, st.changed...), deleted...))
+
+	if len(deleted) > 0 {
+		filter := bson.M{"_id": bson.M{"$in": deleted}}
+		if _, err := wbColl.DeleteMany(ctx, filter); err != nil {
+			log.Println(errors.Wrap(err, "deleting removed wikibooks"))
+		}
+		if _, err := tokenVectorColl.DeleteMany(ctx, filter); err != nil {
+			log.Println(errors.Wrap(err, "deleting removed token vectors"))
+		}
+	}
+
+	writeTokenVectors(ctx, wbArr, true)
+	if len(deleted) > 0 {
+		if _, err := linkColl.DeleteMany(ctx, bson.M{"source_id": bson.M{"$in": deleted}}); err != nil {
+			log.Println(errors.Wrap(err, "deleting removed links"))
+		}
+	}
+	finalSweep(ctx, append(wbArr, virtual...))
+	relinkStubs(ctx, st.added, deleted)
+	bulkWrite(ctx, wbColl, st.hierarchyUpdates(deleted), "updating wikibook hierarchy")
+}
+
+// writeTokens pulls the stale pages out of every token's references, then pushes this run's references
+func (st *incrementalState) writeTokens(ctx context.Context, stale []int) {
+	if len(stale) > 0 {
+		_, err := tokenColl.UpdateMany(ctx,
+			bson.M{"references._id": bson.M{"$in": stale}},
+			bson.M{"$pull": bson.M{"references": bson.M{"_id": bson.M{"$in": stale}}}})
+		if err != nil {
+			err = errors.Wrap(err, "pulling stale token references")
+			log.Fatal(err)
+		}
+	}
+
+	models := make([]mongo.WriteModel, 0, len(allTokens))
+	for _, v := range allTokens {
+		lang, tkn := splitTokenKey(v)
+		refs := make([]idQty, 0, len(tokenRefs[v]))
+		for k := range tokenRefs[v] {
+			refs = append(refs, reference(v, k))
+		}
+		update := bson.M{
+			"$setOnInsert": bson.M{"token": tkn, "lang": lang},
+			"$push":        bson.M{"references": bson.M{"$each": refs}},
+		}
+		if forms := surfaceFormsOf(v); len(forms) > 0 {
+			update["$addToSet"] = bson.M{"surface_forms": bson.M{"$each": forms}}
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": tokenIds[v]}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+	bulkWrite(ctx, tokenColl, models, "updating token references")
+	if len(stale) > 0 {
+		deletePostings(ctx, stale)
+	}
+	if postingPositions {
+		writePostings(ctx)
+	}
+
+	if _, err := tokenColl.DeleteMany(ctx, bson.M{"references": bson.M{"$size": 0}}); err != nil {
+		log.Println(errors.Wrap(err, "deleting unreferenced tokens"))
+	}
+}
+
+// hierarchyUpdates keeps the stored hierarchy in step with this run's parent resolution. Every
+// page whose resolved parent differs from the stored one, because it is new, its parent was added
+// or deleted, or it moved, is pulled from its old parent's child_pages and added to its new one's,
+// and unchanged pages get the new parent_page. Re-tokenized and placeholder pages are replaced
+// whole, so they already carry their parent and full child list.
+func (st *incrementalState) hierarchyUpdates(deleted []int) []mongo.WriteModel {
+	var models []mongo.WriteModel
+	ids := make([]int, 0, len(allWikibooksById))
+	for id := range allWikibooksById {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		wb := allWikibooksById[id]
+		stored := noPage
+		if prev, known := st.pages[wb.Url]; known {
+			stored = prev.ParentPageId
+		}
+		if wb.ParentPageId == stored {
+			continue
+		}
+		if wb.stub {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": id}).
+				SetUpdate(bson.M{"$set": bson.M{"parent_page": wb.ParentPageId}}))
+		}
+		if wb.parentPage != nil && wb.parentPage.stub {
+			models = append(models, childUpdate(wb.ParentPageId, id, 1))
+		}
+		if old := allWikibooksById[stored]; old != nil && old.stub {
+			models = append(models, childUpdate(stored, id, -1))
+		}
+	}
+	for _, id := range deleted {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"child_pages": id}).
+			SetUpdate(bson.M{
+				"$pull": bson.M{"child_pages": id},
+				"$inc":  bson.M{"count_children": -1},
+			}))
+	}
+	return models
+}
+
+// childUpdate adds child to the child_pages of parent, or removes it when delta is negative
+func childUpdate(parent, child, delta int) mongo.WriteModel {
+	op := "$addToSet"
+	if delta < 0 {
+		op = "$pull"
+	}
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"_id": parent}).
+		SetUpdate(bson.M{
+			op:     bson.M{"child_pages": child},
+			"$inc": bson.M{"count_children": delta},
+		})
+}
+
+// saveWatermark records the highest modified value seen, keeping the previous one if this source had none
+func saveWatermark(ctx context.Context, modified string) {
+	update := bson.M{"run_at": time.Now()}
+	if modified != "" {
+		update["modified"] = modified
+	}
+	_, err := stateColl.UpdateOne(ctx, bson.M{"_id": watermarkId}, bson.M{"$set": update}, options.Update().SetUpsert(true))
+	if err != nil {
+		err = errors.Wrap(err, "saving watermark")
+		log.Println(err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// resetPages clears the pages registered by earlier tests
+func resetPages() {
+	allWikibooksByPath, allWikibooksById = make(map[string]*wikibook), make(map[int]*wikibook)
+	wbArr = nil
+}
+
+// updateStrings renders update models as "filter update" for comparison
+func updateStrings(models []mongo.WriteModel) []string {
+	var s []string
+	for _, m := range models {
+		u := m.(*mongo.UpdateOneModel)
+		s = append(s, fmt.Sprint(u.Filter, " ", u.Update))
+	}
+	return s
+}
+
+func TestKeepQuarantinedMarksKnownPagesSeen(t *testing.T) {
+	ed := &edition{Lang: "en", BaseUrl: "https://en.wikibooks.org/wiki/", Site: "en.wikibooks.org"}
+	editionsByLang = map[string]*edition{"en": ed}
+	resetPages()
+	st := &incrementalState{
+		pages: map[string]pageState{ed.BaseUrl + "Cookbook": {Id: 4}},
+		seen:  make(map[int]bool),
+	}
+
+	st.keepQuarantined(ed, ed.BaseUrl+"Cookbook")
+	st.keepQuarantined(ed, ed.BaseUrl+"New_page")
+
+	if !st.seen[4] {
+		t.Error("the quarantined known page was not marked seen, the incremental run would delete it")
+	}
+	if len(st.seen) != 1 {
+		t.Errorf("seen = %v, want only the known page", st.seen)
+	}
+	if wb := allWikibooksById[4]; wb == nil || !wb.stub {
+		t.Error("the kept page was not registered as a stub for hierarchy linking")
+	}
+}
+
+func TestProcessRecord(t *testing.T) {
+	ed := testEdition(t, "en")
+	resetPages()
+	pages = newPageWriter(nil, true)
+	rec := func(loc, body, modified string) sourceRecord {
+		return sourceRecord{Title: loc, Url: ed.BaseUrl + loc, BodyText: body, Modified: modified}
+	}
+	same, edited := rec("Cookbook", "bread and butter", ""), rec("Cookbook/Bread", "new bread recipe", "")
+	old, added := rec("Cookbook/Yeast", "yeast", "2021-01-01"), rec("Cookbook/Flour", "flour", "")
+	st := &incrementalState{
+		pages: map[string]pageState{
+			same.Url:   {Id: 0, Url: same.Url, ContentHash: contentHash(same)},
+			edited.Url: {Id: 1, Url: edited.Url, ContentHash: "stale"},
+			old.Url:    {Id: 2, Url: old.Url, ContentHash: "stale"},
+		},
+		seen:       make(map[int]bool),
+		nextPageId: 3,
+		watermark:  watermark{Modified: "2021-06-01"},
+	}
+	for _, r := range []sourceRecord{same, edited, old, added} {
+		st.processRecord(ed, r)
+	}
+
+	if want := []int{1, 3}; !reflect.DeepEqual(st.changed, want) {
+		t.Errorf("changed = %v, want %v: the edited and the new page", st.changed, want)
+	}
+	if want := []int{3}; !reflect.DeepEqual(st.added, want) {
+		t.Errorf("added = %v, want %v", st.added, want)
+	}
+	if len(st.seen) != 4 {
+		t.Errorf("seen = %v, want every page", st.seen)
+	}
+	for id, stub := range map[int]bool{0: true, 1: false, 2: true, 3: false} {
+		if wb := allWikibooksById[id]; wb == nil || wb.stub != stub {
+			t.Errorf("page %d registered as %+v, want stub %v", id, wb, stub)
+		}
+	}
+	if bread := allWikibooksById[1]; bread.ParentPageId != 0 {
+		t.Errorf("the edited page has parent %d, want the unchanged Cookbook", bread.ParentPageId)
+	}
+}
+
+func TestHierarchyUpdates(t *testing.T) {
+	ed := &edition{Lang: "en", BaseUrl: "https://en.wikibooks.org/wiki/", Site: "en.wikibooks.org"}
+	editionsByLang = map[string]*edition{"en": ed}
+	defer func(m string) { missingParents = m }(missingParents)
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{parentsAncestor, []string{
+			"map[_id:2] map[$set:map[parent_page:0]]",
+			"map[_id:0] map[$addToSet:map[child_pages:2] $inc:map[count_children:1]]",
+			"map[_id:4] map[$set:map[parent_page:5]]",
+			"map[_id:0] map[$inc:map[count_children:-1] $pull:map[child_pages:4]]",
+			"map[_id:0] map[$addToSet:map[child_pages:5] $inc:map[count_children:1]]",
+			"map[child_pages:1] map[$inc:map[count_children:-1] $pull:map[child_pages:1]]",
+		}},
+		{parentsNone, []string{
+			"map[_id:2] map[$set:map[parent_page:-1]]",
+			"map[_id:4] map[$set:map[parent_page:5]]",
+			"map[_id:0] map[$inc:map[count_children:-1] $pull:map[child_pages:4]]",
+			"map[_id:0] map[$addToSet:map[child_pages:5] $inc:map[count_children:1]]",
+			"map[child_pages:1] map[$inc:map[count_children:-1] $pull:map[child_pages:1]]",
+		}},
+	}
+	for _, tt := range tests {
+		missingParents = tt.mode
+		resetPages()
+		// Book/Part (1) was deleted; Book/New (5) is new and becomes the closest parent of Book/New/Ch (4)
+		st := &incrementalState{
+			pages: map[string]pageState{
+				ed.BaseUrl + "Book":        {Id: 0, ParentPageId: noPage},
+				ed.BaseUrl + "Book/Part":   {Id: 1, ParentPageId: 0},
+				ed.BaseUrl + "Book/Part/A": {Id: 2, ParentPageId: 1},
+				ed.BaseUrl + "Book/New/Ch": {Id: 4, ParentPageId: 0},
+			},
+			added: []int{5},
+		}
+		registerStub(ed, 0, ed.BaseUrl+"Book")
+		registerStub(ed, 2, ed.BaseUrl+"Book/Part/A")
+		registerWikibook(&wikibook{Id: 5, Url: ed.BaseUrl + "Book/New", Lang: "en", ParentPageId: noPage})
+		registerStub(ed, 4, ed.BaseUrl+"Book/New/Ch")
+		resolveParents(func(string) int { t.Fatal("no virtual parents expected"); return 0 })
+
+		if got := updateStrings(st.hierarchyUpdates([]int{1})); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got updates\n%q\nwant\n%q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestHierarchyUpdatesReparentsChangedPage(t *testing.T) {
+	ed := &edition{Lang: "en", BaseUrl: "https://en.wikibooks.org/wiki/", Site: "en.wikibooks.org"}
+	editionsByLang = map[string]*edition{"en": ed}
+	resetPages()
+	// Book/Part/Ch (3) was stored under Book (0) and is re-tokenized this run, now below the unchanged Book/Part (6)
+	st := &incrementalState{
+		pages: map[string]pageState{
+			ed.BaseUrl + "Book":         {Id: 0, ParentPageId: noPage},
+			ed.BaseUrl + "Book/Part":    {Id: 6, ParentPageId: 0},
+			ed.BaseUrl + "Book/Part/Ch": {Id: 3, ParentPageId: 0},
+		},
+		changed: []int{3},
+	}
+	registerStub(ed, 0, ed.BaseUrl+"Book")
+	registerStub(ed, 6, ed.BaseUrl+"Book/Part")
+	registerWikibook(&wikibook{Id: 3, Url: ed.BaseUrl + "Book/Part/Ch", Lang: "en", ParentPageId: noPage})
+	resolveParents(func(string) int { t.Fatal("no virtual parents expected"); return 0 })
+
+	want := []string{
+		"map[_id:6] map[$addToSet:map[child_pages:3] $inc:map[count_children:1]]",
+		"map[_id:0] map[$inc:map[count_children:-1] $pull:map[child_pages:3]]",
+	}
+	if got := updateStrings(st.hierarchyUpdates(nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got updates\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"embed"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// LANG_DETECT modes
+const (
+	langDetectOff   = "off"
+	langDetectFlag  = "flag"  // record the detected language, flag pages in another language for review
+	langDetectRoute = "route" // as flag, but tokenize such pages with the detected language's edition when configured
+)
+
+const (
+	langProfileSize = 1000 // most frequent n-grams kept per profile
+	langSampleBytes = 8192 // text of a page looked at for detection
+	langMinLetters  = 100  // pages with fewer letters are not classified
+)
+
+// langSamples are sample texts per language, langprofiles/<lang>.txt, that the n-gram profiles are built from
+//
+//go:embed langprofiles
+var langSamples embed.FS
+
+var (
+	langDetect        = envOr("LANG_DETECT", langDetectOff)
+	langMinConfidence = 0.1
+	langProfiles      = make(map[string]map[string]int) // n-gram ranks by language
+	langRouted        int
+	langFlagged       int
+)
+
+// loadLangProfiles builds the n-gram profiles from the shipped samples and any <lang>.txt files in
+// LANG_PROFILES_DIR, which add languages or replace shipped ones
+func loadLangProfiles() error {
+	switch langDetect {
+	case langDetectOff:
+		return nil
+	case langDetectFlag, langDetectRoute:
+	default:
+		return errors.Errorf("LANG_DETECT must be %s, %s or %s, not %q", langDetectOff, langDetectFlag, langDetectRoute, langDetect)
+	}
+	if f, err := strconv.ParseFloat(envOr("LANG_MIN_CONFIDENCE", ""), 64); err == nil && f >= 0 && f <= 1 {
+		langMinConfidence = f
+	}
+
+	samples, err := langSamples.ReadDir("langprofiles")
+	if err != nil {
+		return errors.Wrap(err, "reading shipped language samples")
+	}
+	for _, s := range samples {
+		b, err := langSamples.ReadFile("langprofiles/" + s.Name())
+		if err != nil {
+			return errors.Wrapf(err, "reading shipped language sample %s", s.Name())
+		}
+		langProfiles[strings.TrimSuffix(s.Name(), ".txt")] = ngramProfile(string(b))
+	}
+	if dir := os.Getenv("LANG_PROFILES_DIR"); dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+		if err != nil {
+			return errors.Wrapf(err, "listing language samples in %s", dir)
+		}
+		for _, p := range paths {
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return errors.Wrapf(err, "reading language sample %s", p)
+			}
+			langProfiles[strings.TrimSuffix(filepath.Base(p), ".txt")] = ngramProfile(string(b))
+		}
+	}
+	log.Printf("language detection: %d language profiles, mode %s", len(langProfiles), langDetect)
+	return nil
+}
+
+// ngramProfile ranks the 1 to 3 letter n-grams of text's words, padded with '_' at both ends,
+// by descending frequency, keeping the langProfileSize most frequent
+func ngramProfile(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		rs := []rune("_" + w + "_")
+		for n := 1; n <= 3; n++ {
+			for i := 0; i+n <= len(rs); i++ {
+				if g := string(rs[i : i+n]); g != "_" {
+					counts[g]++
+				}
+			}
+		}
+	}
+	grams := make([]string, 0, len(counts))
+	for g := range counts {
+		grams = append(grams, g)
+	}
+	sort.Slice(grams, func(i, j int) bool {
+		if counts[grams[i]] != counts[grams[j]] {
+			return counts[grams[i]] > counts[grams[j]]
+		}
+		return grams[i] < grams[j]
+	})
+	if len(grams) > langProfileSize {
+		grams = grams[:langProfileSize]
+	}
+	ranks := make(map[string]int, len(grams))
+	for i, g := range grams {
+		ranks[g] = i
+	}
+	return ranks
+}
+
+// detectLang returns the language whose profile is nearest to text by out-of-place distance, with
+// a confidence in [0, 1]: how much farther the runner-up is, relative to the runner-up's distance.
+// Texts with too few letters give "".
+func detectLang(text string) (string, float64) {
+	if len(text) > langSampleBytes {
+		cut := langSampleBytes
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < langMinLetters || len(langProfiles) == 0 {
+		return "", 0
+	}
+	doc := ngramProfile(text)
+	best, bestDist, secondDist := "", math.MaxInt64, math.MaxInt64
+	for lang, profile := range langProfiles {
+		dist := 0
+		for g, r := range doc {
+			if pr, ok := profile[g]; ok {
+				if pr > r {
+					dist += pr - r
+				} else {
+					dist += r - pr
+				}
+			} else {
+				dist += langProfileSize
+			}
+		}
+		if dist < bestDist || (dist == bestDist && lang < best) {
+			best, bestDist, secondDist = lang, dist, bestDist
+		} else if dist < secondDist {
+			secondDist = dist
+		}
+	}
+	if secondDist == math.MaxInt64 || secondDist == 0 {
+		return best, 1
+	}
+	return best, float64(secondDist-bestDist) / float64(secondDist)
+}
+
+// detectPageLang records the language of wb's body and decides how a page that seems to be in another
+// language than its edition is handled: routed to that language's edition, or flagged for review
+func detectPageLang(wb *wikibook) {
+	lang, conf := detectLang(docText(*wb))
+	wb.DetectedLang, wb.LangConfidence = lang, conf
+	if lang == "" || lang == wb.Lang || conf < langMinConfidence {
+		return
+	}
+	if _, ok := editionsByLang[lang]; ok && langDetect == langDetectRoute {
+		wb.TokenLang = lang
+		langRouted++
+		return
+	}
+	wb.LangReview = true
+	langFlagged++
+}
+
+// tokenLang is the language whose edition tokenizes the page, normally its own
+func (wb *wikibook) tokenLang() string {
+	if wb.TokenLang != "" {
+		return wb.TokenLang
+	}
+	return wb.Lang
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+// langHeldOut are short page-like texts, none taken from the shipped samples, to check detection with
+var langHeldOut = map[string][]string{
+	"en": {
+		"To make a simple tomato sauce, warm some olive oil in a pan, add chopped garlic and cook it gently until it smells sweet. Then pour in the tomatoes and let the sauce simmer for twenty minutes.",
+		"A variable is a name that refers to a value stored in memory. In most programming languages you must declare a variable before you use it, and its type decides which operations are allowed.",
+		"The empire grew quickly during the first century, but its wealth depended on long trade routes that were difficult to defend when neighbouring kingdoms went to war with each other.",
+		"A prime number has exactly two divisors, one and itself. The first few primes are two, three, five, seven and eleven, and there are infinitely many of them, as Euclid proved long ago.",
+		"When sodium reacts with water it releases hydrogen gas and a great deal of heat, which is why this experiment should only be shown by a teacher wearing safety glasses.",
+		"The guitar has six strings that are usually tuned to the notes E, A, D, G, B and E. Beginners often start by learning a few open chords before they try to play melodies.",
+		"Cells are the smallest units of life. Every living organism is made of one or more cells, and each cell contains the genetic material that tells it how to grow and divide.",
+		"The goalkeeper is the only player on the team who may handle the ball, and only inside the penalty area. A match lasts ninety minutes, divided into two halves of forty five minutes.",
+		"When prices rise faster than wages, households can buy fewer goods with the same income. Central banks try to keep inflation low and stable by changing the interest rates they charge.",
+		"Before you travel abroad, check that your passport is still valid for at least six months, find out whether you need a visa, and make copies of your important documents.",
+	},
+	"de": {
+		"Für eine einfache Tomatensoße erhitzt man etwas Olivenöl in einer Pfanne, gibt gehackten Knoblauch dazu und brät ihn vorsichtig an. Danach kommen die Tomaten hinein, und die Soße köchelt zwanzig Minuten.",
+		"Eine Variable ist ein Name, der auf einen Wert im Speicher verweist. In den meisten Programmiersprachen muss man eine Variable deklarieren, bevor man sie benutzt, und ihr Typ bestimmt, welche Operationen erlaubt sind.",
+		"Das Reich wuchs im ersten Jahrhundert sehr schnell, doch sein Reichtum hing von langen Handelswegen ab, die schwer zu verteidigen waren, wenn die benachbarten Königreiche gegeneinander Krieg führten.",
+		"Eine Primzahl hat genau zwei Teiler, nämlich eins und sich selbst. Die ersten Primzahlen sind zwei, drei, fünf, sieben und elf, und es gibt unendlich viele davon, wie schon Euklid bewiesen hat.",
+		"Wenn Natrium mit Wasser reagiert, entstehen Wasserstoff und sehr viel Wärme. Deshalb sollte dieser Versuch nur von einer Lehrkraft mit Schutzbrille vorgeführt werden.",
+		"Die Gitarre hat sechs Saiten, die meistens auf die Töne E, A, D, G, H und E gestimmt werden. Anfänger lernen oft zuerst ein paar einfache Akkorde, bevor sie Melodien spielen.",
+		"Zellen sind die kleinsten Einheiten des Lebens. Jedes Lebewesen besteht aus einer oder mehreren Zellen, und jede Zelle enthält das Erbgut, das ihr sagt, wie sie wachsen und sich teilen soll.",
+		"Der Torwart ist der einzige Spieler der Mannschaft, der den Ball mit den Händen berühren darf, und zwar nur im Strafraum. Ein Spiel dauert neunzig Minuten und ist in zwei Hälften geteilt.",
+		"Wenn die Preise schneller steigen als die Löhne, können Haushalte mit dem gleichen Einkommen weniger kaufen. Die Zentralbanken versuchen, die Inflation durch ihre Zinsen niedrig und stabil zu halten.",
+		"Bevor du ins Ausland reist, solltest du prüfen, ob dein Reisepass noch mindestens sechs Monate gültig ist, ob du ein Visum brauchst, und Kopien deiner wichtigen Dokumente machen.",
+	},
+	"fr": {
+		"Pour préparer une sauce tomate simple, faites chauffer un peu d'huile d'olive dans une poêle, ajoutez de l'ail haché et laissez-le cuire doucement. Versez ensuite les tomates et laissez mijoter vingt minutes.",
+		"Une variable est un nom qui désigne une valeur rangée en mémoire. Dans la plupart des langages de programmation, il faut déclarer une variable avant de l'utiliser, et son type détermine les opérations permises.",
+		"L'empire s'est agrandi très vite au cours du premier siècle, mais sa richesse dépendait de longues routes commerciales qu'il était difficile de défendre lorsque les royaumes voisins se faisaient la guerre.",
+		"Un nombre premier possède exactement deux diviseurs, un et lui-même. Les premiers nombres premiers sont deux, trois, cinq, sept et onze, et il en existe une infinité, comme Euclide l'a démontré.",
+		"Quand le sodium réagit avec l'eau, il libère de l'hydrogène et beaucoup de chaleur. C'est pourquoi cette expérience ne doit être montrée que par un enseignant qui porte des lunettes de protection.",
+		"La guitare possède six cordes qui sont le plus souvent accordées sur les notes mi, la, ré, sol, si et mi. Les débutants apprennent d'abord quelques accords simples avant de jouer des mélodies.",
+		"Les cellules sont les plus petites unités du vivant. Chaque être vivant est formé d'une ou de plusieurs cellules, et chaque cellule contient le matériel génétique qui lui indique comment grandir et se diviser.",
+		"Le gardien de but est le seul joueur de l'équipe qui a le droit de toucher le ballon avec les mains, et seulement dans la surface de réparation. Un match dure quatre-vingt-dix minutes.",
+		"Lorsque les prix augmentent plus vite que les salaires, les ménages peuvent acheter moins de biens avec le même revenu. Les banques centrales essaient de maintenir une inflation basse et stable.",
+		"Avant de partir à l'étranger, vérifiez que votre passeport est encore valable pendant au moins six mois, renseignez-vous pour savoir s'il vous faut un visa et faites des copies de vos documents importants.",
+	},
+	"es": {
+		"Para hacer una salsa de tomate sencilla, calienta un poco de aceite de oliva en una sartén, añade ajo picado y cocínalo a fuego lento. Después agrega los tomates y deja que la salsa hierva veinte minutos.",
+		"Una variable es un nombre que se refiere a un valor guardado en la memoria. En la mayoría de los lenguajes de programación hay que declarar una variable antes de usarla, y su tipo decide qué operaciones se permiten.",
+		"El imperio creció muy deprisa durante el primer siglo, pero su riqueza dependía de largas rutas comerciales que eran difíciles de defender cuando los reinos vecinos entraban en guerra entre sí.",
+		"Un número primo tiene exactamente dos divisores, el uno y él mismo. Los primeros números primos son el dos, el tres, el cinco, el siete y el once, y hay infinitos, como demostró Euclides hace mucho tiempo.",
+		"Cuando el sodio reacciona con el agua libera hidrógeno y mucho calor, y por eso este experimento solo debe hacerlo un profesor que lleve gafas de protección delante de los alumnos.",
+		"La guitarra tiene seis cuerdas que normalmente se afinan en las notas mi, la, re, sol, si y mi. Los principiantes suelen empezar aprendiendo algunos acordes sencillos antes de tocar melodías.",
+		"Las células son las unidades más pequeñas de la vida. Todos los seres vivos están formados por una o varias células, y cada célula contiene el material genético que le indica cómo crecer y dividirse.",
+		"El portero es el único jugador del equipo que puede tocar el balón con las manos, y solo dentro del área. Un partido dura noventa minutos, divididos en dos tiempos de cuarenta y cinco minutos.",
+		"Cuando los precios suben más deprisa que los sueldos, las familias pueden comprar menos cosas con los mismos ingresos. Los bancos centrales intentan mantener la inflación baja y estable con los tipos de interés.",
+		"Antes de viajar al extranjero, comprueba que tu pasaporte sigue siendo válido durante al menos seis meses, averigua si necesitas un visado y haz copias de tus documentos más importantes.",
+	},
+	"it": {
+		"Per preparare un semplice sugo di pomodoro, scalda un po' di olio d'oliva in una padella, aggiungi l'aglio tritato e fallo cuocere piano. Poi versa i pomodori e lascia sobbollire il sugo per venti minuti.",
+		"Una variabile è un nome che si riferisce a un valore conservato in memoria. Nella maggior parte dei linguaggi di programmazione bisogna dichiarare una variabile prima di usarla, e il suo tipo stabilisce quali operazioni sono permesse.",
+		"L'impero crebbe molto in fretta durante il primo secolo, ma la sua ricchezza dipendeva da lunghe vie commerciali che erano difficili da difendere quando i regni vicini si facevano la guerra.",
+		"Un numero primo ha esattamente due divisori, l'uno e se stesso. I primi numeri primi sono due, tre, cinque, sette e undici, e ce ne sono infiniti, come dimostrò Euclide moltissimo tempo fa.",
+		"Quando il sodio reagisce con l'acqua libera idrogeno e moltissimo calore, perciò questo esperimento dovrebbe essere mostrato soltanto da un insegnante che indossa gli occhiali di protezione.",
+		"La chitarra ha sei corde che di solito vengono accordate sulle note mi, la, re, sol, si e mi. I principianti spesso imparano prima alcuni accordi semplici e solo dopo cominciano a suonare le melodie.",
+		"Le cellule sono le unità più piccole della vita. Ogni essere vivente è fatto di una o più cellule, e ogni cellula contiene il materiale genetico che le dice come crescere e come dividersi.",
+		"Il portiere è l'unico giocatore della squadra che può toccare il pallone con le mani, e soltanto dentro l'area di rigore. Una partita dura novanta minuti, divisi in due tempi di quarantacinque minuti.",
+		"Quando i prezzi aumentano più in fretta degli stipendi, le famiglie possono comprare meno cose con lo stesso reddito. Le banche centrali cercano di mantenere l'inflazione bassa e stabile con i tassi di interesse.",
+		"Prima di partire per l'estero, controlla che il tuo passaporto sia valido per almeno sei mesi, informati se hai bisogno di un visto e fai delle copie dei tuoi documenti più importanti.",
+	},
+	"nl": {
+		"Voor een eenvoudige tomatensaus verwarm je wat olijfolie in een pan, voeg je gehakte knoflook toe en laat je die zachtjes bakken. Daarna gaan de tomaten erbij en laat je de saus twintig minuten pruttelen.",
+		"Een variabele is een naam die verwijst naar een waarde in het geheugen. In de meeste programmeertalen moet je een variabele declareren voordat je hem gebruikt, en het type bepaalt welke bewerkingen zijn toegestaan.",
+		"Het rijk groeide in de eerste eeuw heel snel, maar de rijkdom hing af van lange handelsroutes die moeilijk te verdedigen waren wanneer de naburige koninkrijken met elkaar in oorlog waren.",
+		"Een priemgetal heeft precies twee delers, namelijk één en zichzelf. De eerste priemgetallen zijn twee, drie, vijf, zeven en elf, en er zijn er oneindig veel, zoals Euclides lang geleden al bewees.",
+		"Wanneer natrium met water reageert, komen er waterstof en heel veel warmte vrij. Daarom mag deze proef alleen worden getoond door een leraar die een veiligheidsbril draagt.",
+		"De gitaar heeft zes snaren die meestal worden gestemd op de tonen E, A, D, G, B en E. Beginners leren vaak eerst een paar eenvoudige akkoorden voordat ze melodieën gaan spelen.",
+		"Cellen zijn de kleinste eenheden van het leven. Elk levend wezen bestaat uit een of meer cellen, en elke cel bevat het erfelijk materiaal dat haar vertelt hoe ze moet groeien en zich moet delen.",
+		"De keeper is de enige speler van het team die de bal met de handen mag aanraken, en dan alleen in het strafschopgebied. Een wedstrijd duurt negentig minuten, verdeeld over twee helften.",
+		"Als de prijzen sneller stijgen dan de lonen, kunnen huishoudens met hetzelfde inkomen minder kopen. Centrale banken proberen de inflatie laag en stabiel te houden door hun rente aan te passen.",
+		"Voordat je naar het buitenland reist, moet je controleren of je paspoort nog minstens zes maanden geldig is, uitzoeken of je een visum nodig hebt en kopieën maken van je belangrijke documenten.",
+	},
+	"pt": {
+		"Para fazer um molho de tomate simples, aqueça um pouco de azeite numa frigideira, junte alho picado e deixe-o cozinhar devagar. Depois acrescente os tomates e deixe o molho ferver em lume brando durante vinte minutos.",
+		"Uma variável é um nome que se refere a um valor guardado na memória. Na maioria das linguagens de programação é preciso declarar uma variável antes de a usar, e o seu tipo determina quais operações são permitidas.",
+		"O império cresceu muito depressa durante o primeiro século, mas a sua riqueza dependia de longas rotas comerciais que eram difíceis de defender quando os reinos vizinhos entravam em guerra uns com os outros.",
+		"Um número primo tem exatamente dois divisores, o um e ele próprio. Os primeiros números primos são o dois, o três, o cinco, o sete e o onze, e existem infinitos, como Euclides demonstrou há muito tempo.",
+		"Quando o sódio reage com a água, liberta hidrogénio e muito calor, e por isso esta experiência só deve ser mostrada por um professor que use óculos de proteção à frente dos alunos.",
+		"A guitarra tem seis cordas que normalmente são afinadas nas notas mi, lá, ré, sol, si e mi. Os principiantes costumam começar por aprender alguns acordes simples antes de tocarem melodias.",
+		"As células são as unidades mais pequenas da vida. Todos os seres vivos são formados por uma ou mais células, e cada célula contém o material genético que lhe diz como crescer e como se dividir.",
+		"O guarda-redes é o único jogador da equipa que pode tocar na bola com as mãos, e apenas dentro da grande área. Um jogo dura noventa minutos, divididos em duas partes de quarenta e cinco minutos.",
+		"Quando os preços sobem mais depressa do que os salários, as famílias conseguem comprar menos coisas com o mesmo rendimento. Os bancos centrais tentam manter a inflação baixa e estável através das taxas de juro.",
+		"Antes de viajar para o estrangeiro, confirme que o seu passaporte continua válido durante pelo menos seis meses, descubra se precisa de visto e faça cópias dos seus documentos mais importantes.",
+	},
+}
+
+// TestDetectLangAccuracy checks the shipped profiles against langHeldOut: every language must be
+// recognized, with at least langMinConfidence, in 9 of its 10 texts
+func TestDetectLangAccuracy(t *testing.T) {
+	defer func(m string, p map[string]map[string]int) { langDetect, langProfiles = m, p }(langDetect, langProfiles)
+	langDetect, langProfiles = langDetectFlag, make(map[string]map[string]int)
+	if err := loadLangProfiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	correct, total := 0, 0
+	for lang, texts := range langHeldOut {
+		ok := 0
+		for _, text := range texts {
+			got, conf := detectLang(text)
+			if got == lang && conf >= langMinConfidence {
+				ok++
+			} else {
+				t.Logf("%s text detected as %s (confidence %.2f): %.60s", lang, got, conf, text)
+			}
+		}
+		if ok < len(texts)*9/10 {
+			t.Errorf("%s: %d of %d texts recognized", lang, ok, len(texts))
+		}
+		correct += ok
+		total += len(texts)
+	}
+	t.Logf("accuracy %d of %d", correct, total)
+}
+
+func TestRouteToTokenizationOnlyEdition(t *testing.T) {
+	defer func(m string, p map[string]map[string]int) { langDetect, langProfiles = m, p }(langDetect, langProfiles)
+	langDetect, langProfiles = langDetectRoute, make(map[string]map[string]int)
+	if err := loadLangProfiles(); err != nil {
+		t.Fatal(err)
+	}
+	de := &edition{Lang: "de", Source: "none"}
+	editionsByLang = map[string]*edition{"en": {Lang: "en"}, "de": de}
+
+	src, err := openSource(de)
+	if err != nil {
+		t.Fatalf("opening a tokenization-only edition: %v", err)
+	}
+	if _, err = src.Next(); err != io.EOF {
+		t.Errorf("a tokenization-only edition yielded a record, err %v", err)
+	}
+
+	wb := &wikibook{Lang: "en", BodyText: langHeldOut["de"][2]}
+	detectPageLang(wb)
+	if wb.tokenLang() != "de" || wb.LangReview {
+		t.Errorf("german page on the english edition tokenized as %q, review %v", wb.tokenLang(), wb.LangReview)
+	}
+}
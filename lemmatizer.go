@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// dictionaryLemmatizer maps English inflected forms to lemmas found in the edition dictionary,
+	// so unlike the stem stage it always yields real words. Irregular forms come from a fixed table;
+	// regular ones are undone by suffix rules whose candidates are checked against the dictionary.
+	// It belongs before the dictionary stage, which would drop forms like "children".
+	dictionaryLemmatizer struct {
+		ed *edition
+	}
+	// lemmaRule rewrites a suffix. double only fits stems ending in a doubled consonant and strips
+	// one of them, as in "running"; keepDouble keeps a doubled l, f or z, the ones English words end
+	// in, as in "killing".
+	lemmaRule struct {
+		suffix, repl string
+		double       bool
+		keepDouble   bool
+	}
+)
+
+var (
+	// irregularLemmas covers common irregular verbs and plurals; forms that are just as often
+	// unrelated words, like "left", "saw", "rose", "thought", "lost" or "better", are deliberately missing
+	irregularLemmas = map[string]string{
+		"am": "be", "is": "be", "are": "be", "was": "be", "were": "be", "been": "be",
+		"has": "have", "had": "have", "having": "have", "did": "do", "done": "do",
+		"went": "go", "gone": "go", "ran": "run", "made": "make", "said": "say", "seen": "see",
+		"took": "take", "taken": "take", "came": "come", "got": "get", "gotten": "get",
+		"gave": "give", "knew": "know", "known": "know",
+		"told": "tell", "became": "become", "brought": "bring", "began": "begin",
+		"begun": "begin", "kept": "keep", "held": "hold", "wrote": "write", "written": "write",
+		"stood": "stand", "heard": "hear", "meant": "mean", "met": "meet", "paid": "pay", "sat": "sit",
+		"spoke": "speak", "spoken": "speak", "grew": "grow", "grown": "grow",
+		"sent": "send", "built": "build", "understood": "understand",
+		"drawn": "draw", "drove": "drive", "driven": "drive", "bought": "buy", "wore": "wear", "worn": "wear",
+		"chose": "choose", "chosen": "choose", "sought": "seek", "threw": "throw", "thrown": "throw",
+		"caught": "catch", "dealt": "deal", "fought": "fight", "taught": "teach",
+		"sold": "sell", "ate": "eat", "eaten": "eat", "slept": "sleep", "flew": "fly", "flown": "fly",
+		"forgot": "forget", "forgotten": "forget", "hid": "hide", "shook": "shake",
+		"shaken": "shake", "sang": "sing", "sung": "sing", "swam": "swim", "swum": "swim",
+		"children": "child", "men": "man", "women": "woman", "mice": "mouse", "geese": "goose",
+		"feet": "foot", "teeth": "tooth", "oxen": "ox", "indices": "index", "matrices": "matrix",
+		"vertices": "vertex", "analyses": "analysis", "theses": "thesis", "criteria": "criterion",
+		"phenomena": "phenomenon",
+	}
+	// lemmaStrongRules are tried even for words that are in the dictionary themselves, since their
+	// spelling changes are unlikely outside of inflection, e.g. "studied" or "running". -ies to -ie
+	// comes before -ies to -y, so the -y form only wins when the dictionary lacks the -ie one.
+	// A doubled consonant is kept when that gives a word, so "killing" is "kill" and not "kil".
+	lemmaStrongRules = []lemmaRule{
+		{suffix: "ies", repl: "ie"}, {suffix: "ies", repl: "y"}, {suffix: "ied", repl: "y"},
+		{suffix: "ing", keepDouble: true}, {suffix: "ing", double: true},
+		{suffix: "ed", keepDouble: true}, {suffix: "ed", double: true},
+	}
+	// lemmaRules are tried in order for words missing from the dictionary; the first candidate
+	// that is a dictionary word wins
+	lemmaRules = []lemmaRule{
+		{suffix: "ies", repl: "ie"}, {suffix: "ies", repl: "y"}, {suffix: "ied", repl: "y"}, {suffix: "ier", repl: "y"}, {suffix: "iest", repl: "y"},
+		{suffix: "ves", repl: "f"}, {suffix: "ves", repl: "fe"}, {suffix: "men", repl: "man"},
+		{suffix: "s"}, {suffix: "es"},
+		{suffix: "ing", repl: "e"}, {suffix: "ing"}, {suffix: "ing", double: true},
+		{suffix: "ed", repl: "e"}, {suffix: "ed"}, {suffix: "ed", double: true},
+		{suffix: "er", repl: "e"}, {suffix: "er"}, {suffix: "er", double: true},
+		{suffix: "est", repl: "e"}, {suffix: "est"}, {suffix: "est", double: true},
+	}
+)
+
+func init() {
+	tokenStages["lemma"] = func(ed *edition) (interface{}, error) {
+		if ed.Lang != "en" {
+			return nil, errors.Errorf("the lemma stage only supports English, not %s", ed.Lang)
+		}
+		return dictionaryLemmatizer{ed}, nil
+	}
+}
+
+func (dictionaryLemmatizer) conflates() {}
+
+// Normalize returns the lemma of tkn, or tkn itself when no rule leads to a dictionary word
+func (l dictionaryLemmatizer) Normalize(tkn string) string {
+	if lemma, ok := irregularLemmas[tkn]; ok && l.ed.dictionary[lemma] {
+		return lemma
+	}
+	rules := lemmaRules
+	if l.ed.dictionary[tkn] {
+		rules = lemmaStrongRules
+	}
+	for _, r := range rules {
+		if c, ok := r.apply(tkn); ok && l.ed.dictionary[c] {
+			return c
+		}
+	}
+	return tkn
+}
+
+// apply returns the candidate lemma for w, or false if the rule does not fit it. Candidates
+// keep at least three letters and a vowel, and plain -s is not stripped from -ss, -us or -is.
+func (r lemmaRule) apply(w string) (string, bool) {
+	if !strings.HasSuffix(w, r.suffix) {
+		return "", false
+	}
+	stem := w[:len(w)-len(r.suffix)]
+	if r.suffix == "s" && (strings.HasSuffix(stem, "s") || strings.HasSuffix(stem, "u") || strings.HasSuffix(stem, "i")) {
+		return "", false
+	}
+	if r.double || r.keepDouble {
+		n := len(stem)
+		if n < 2 || stem[n-1] != stem[n-2] || strings.IndexByte("bdfgklmnprtz", stem[n-1]) < 0 {
+			return "", false
+		}
+		if r.double {
+			stem = stem[:n-1]
+		} else if strings.IndexByte("flz", stem[n-1]) < 0 {
+			return "", false
+		}
+	}
+	c := stem + r.repl
+	if len(c) < 3 || !strings.ContainsAny(c, "aeiouy") {
+		return "", false
+	}
+	return c, true
+}
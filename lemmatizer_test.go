@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+
+// lemmaDictionary is a small English dictionary for the lemmatizer tests
+func lemmaDictionary(words ...string) *edition {
+	ed := &edition{Lang: "en", dictionary: make(map[string]bool)}
+	for _, w := range words {
+		ed.dictionary[w] = true
+	}
+	return ed
+}
+
+func TestLemmatizer(t *testing.T) {
+	l := dictionaryLemmatizer{lemmaDictionary(
+		"child", "study", "run", "make", "stop", "wolf", "knife", "box", "cat", "big", "happy",
+		"glass", "status", "morning", "thing", "go", "be", "analysis", "hope", "hop",
+		"cookie", "cooky", "movie", "pony", "think", "thought", "lose", "lost", "good", "better", "lead",
+	)}
+	tests := map[string]string{
+		"children": "child",    // irregular
+		"went":     "go",       // irregular
+		"were":     "be",       // irregular
+		"analyses": "analysis", // irregular
+		"studied":  "study",    // -ied
+		"studies":  "study",    // -ies
+		"happier":  "happy",    // -ier
+		"happiest": "happy",    // -iest
+		"wolves":   "wolf",     // -ves to -f
+		"knives":   "knife",    // -ves to -fe
+		"cats":     "cat",      // -s
+		"boxes":    "box",      // -es
+		"making":   "make",     // -ing restoring e
+		"hoped":    "hope",     // -ed restoring e
+		"hopped":   "hop",      // -ed with a doubled consonant
+		"running":  "run",      // -ing with a doubled consonant, even though running is no dictionary word
+		"stopped":  "stop",     // -ed with a doubled consonant
+		"bigger":   "big",      // -er with a doubled consonant
+		"glass":    "glass",    // -ss is not a plural
+		"status":   "status",   // nor is -us
+		"morning":  "morning",  // dictionary words only take the strong rules
+		"thing":    "thing",
+		"blorfing": "blorfing", // no candidate in the dictionary
+		"cookies":  "cookie",   // -ies to -ie wins over -ies to -y when both are words
+		"movies":   "movie",
+		"ponies":   "pony",
+		"thought":  "thought", // ambiguous forms are left alone
+		"lost":     "lost",
+		"better":   "better",
+		"led":      "led",
+	}
+	for w, want := range tests {
+		if got := l.Normalize(w); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", w, got, want)
+		}
+	}
+}
+
+func TestLemmaRuleApply(t *testing.T) {
+	tests := []struct {
+		rule lemmaRule
+		w    string
+		want string
+		ok   bool
+	}{
+		{lemmaRule{suffix: "ing", double: true}, "running", "run", true},
+		{lemmaRule{suffix: "ing", double: true}, "calling", "cal", true},
+		{lemmaRule{suffix: "ing", double: true}, "morning", "", false}, // rn is no doubled consonant
+		{lemmaRule{suffix: "ed", double: true}, "passed", "", false},   // s is never doubled by inflection
+		{lemmaRule{suffix: "s"}, "bus", "", false},
+		{lemmaRule{suffix: "s"}, "axis", "", false},
+		{lemmaRule{suffix: "ing"}, "sing", "", false}, // too short
+		{lemmaRule{suffix: "s"}, "cwms", "", false},   // no vowel
+		{lemmaRule{suffix: "ies", repl: "y"}, "ponies", "pony", true},
+		{lemmaRule{suffix: "ing", keepDouble: true}, "killing", "kill", true},
+		{lemmaRule{suffix: "ing", keepDouble: true}, "running", "", false}, // n is no doubled word ending
+		{lemmaRule{suffix: "ed", keepDouble: true}, "stuffed", "stuff", true},
+	}
+	for _, tt := range tests {
+		got, ok := tt.rule.apply(tt.w)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%+v.apply(%q) = %q, %v, want %q, %v", tt.rule, tt.w, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLemmatizerShippedDictionary(t *testing.T) {
+	ed := &edition{Lang: "en", DictionaryPath: "en"}
+	ed.loadDictionary()
+	l := dictionaryLemmatizer{ed}
+	tests := map[string]string{
+		"killing":   "kill",
+		"polling":   "poll",
+		"chilled":   "chill",
+		"balled":    "ball",
+		"milled":    "mill",
+		"shelling":  "shell",
+		"stuffed":   "stuff",
+		"running":   "run",
+		"stopped":   "stop",
+		"travelled": "travel",
+		"studied":   "study",
+		"children":  "child",
+		"morning":   "morning",
+	}
+	for w, want := range tests {
+		if got := l.Normalize(w); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", w, got, want)
+		}
+	}
+}
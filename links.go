@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	linkInternal = "internal"
+	linkExternal = "external"
+)
+
+type (
+	// PageLink is a typed edge parsed from an anchor in BodyHtml
+	PageLink struct {
+		Kind       string `json:"kind" bson:"kind"`
+		TargetId   int    `json:"target_id" bson:"target_id"`                         // internal only, noPage when unresolved
+		TargetPath string `json:"target_path,omitempty" bson:"target_path,omitempty"` // internal only, page location below the edition base url
+		Site       string `json:"site,omitempty" bson:"site,omitempty"`               // internal only, site of the edition holding the target
+		Domain     string `json:"domain,omitempty" bson:"domain,omitempty"`           // external only
+		Url        string `json:"url,omitempty" bson:"url,omitempty"`                 // external only
+		Text       string `json:"text" bson:"text"`
+	}
+	// linkDoc is a PageLink stored in the links collection; the embedded type must stay exported
+	// for the bson inline to take effect
+	linkDoc struct {
+		Id       primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+		SourceId int                `json:"source_id" bson:"source_id"`
+		PageLink `bson:",inline"`
+	}
+)
+
+// extractLinks parses the anchors of a page's html into typed edges, resolving relative hrefs
+// against pageUrl, the url of the page or of its file. Links into any configured edition are internal.
+// Same-page fragments, wiki action links (/w/index.php) and non-http schemes are not edges.
+func extractLinks(s, pageUrl string) []PageLink {
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil
+	}
+
+	var (
+		links  []PageLink
+		inA    bool
+		href   string
+		anchor strings.Builder
+	)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			break
+		}
+		if inA {
+			anchor.WriteString(s[:i])
+		}
+		s = s[i:]
+		j := strings.IndexByte(s, '>')
+		if j < 0 {
+			break
+		}
+		tag, ok := parseHtmlTag(s[1:j])
+		s = s[j+1:]
+		if !ok || tag.name != "a" {
+			continue
+		}
+		if !tag.end {
+			inA = true
+			href = htmlAttr(tag.attrs, "href")
+			anchor.Reset()
+			continue
+		}
+		if !inA {
+			continue
+		}
+		inA = false
+		text := strings.Join(strings.Fields(html.UnescapeString(anchor.String())), " ")
+		if l, ok := classifyLink(base, href, text); ok {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// classifyLink resolves href against the page's url and types it as internal or external
+func classifyLink(base *url.URL, href, text string) (PageLink, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return PageLink{}, false
+	}
+	u, err := base.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return PageLink{}, false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if target, ok := editionsBySite[host]; ok {
+		targetBase, _ := url.Parse(target.BaseUrl)
+		if !strings.HasPrefix(u.Path, targetBase.Path) {
+			return PageLink{}, false
+		}
+		targetPath := strings.TrimPrefix(u.Path, targetBase.Path)
+		if target.Source == "dir" {
+			targetPath = dirLinkLoc(targetPath)
+		}
+		return PageLink{
+			Kind:       linkInternal,
+			TargetId:   noPage,
+			TargetPath: targetPath,
+			Site:       target.Site,
+			Text:       text,
+		}, true
+	}
+	host = strings.TrimPrefix(host, "www.")
+	u.Fragment = ""
+	return PageLink{
+		Kind:     linkExternal,
+		TargetId: noPage,
+		Domain:   host,
+		Url:      u.String(),
+		Text:     text,
+	}, true
+}
+
+// countLinks derives a page's link counts from its parsed edges
+func countLinks(wb *wikibook) {
+	wb.CountExternalLinks, wb.CountInternalLinks = 0, 0
+	for _, l := range wb.Links {
+		if l.Kind == linkExternal {
+			wb.CountExternalLinks++
+		} else {
+			wb.CountInternalLinks++
+		}
+	}
+}
+
+// resolveLinks reads back the edges the page writer stored for wbs, points internal edges at page
+// ids now that every page has been registered, and fills in each page's Links for the final sweep.
+// It reports false when the edges could not be read, leaving Links unset.
+func resolveLinks(ctx context.Context, wbs []*wikibook) bool {
+	byId := make(map[int]*wikibook, len(wbs))
+	ids := make([]int, 0, len(wbs))
+	for _, wb := range wbs {
+		if wb.CountInternalLinks+wb.CountExternalLinks > 0 || wb.stub {
+			byId[wb.Id] = wb
+			ids = append(ids, wb.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return true
+	}
+	cur, err := linkColl.Find(ctx, bson.M{"source_id": bson.M{"$in": ids}})
+	if err != nil {
+		err = errors.Wrap(err, "reading links")
+		log.Println(err)
+		return false
+	}
+	var docs []linkDoc
+	if err = cur.All(ctx, &docs); err != nil {
+		err = errors.Wrap(err, "decoding links")
+		log.Println(err)
+		return false
+	}
+	// object ids grow in insertion order, which keeps each page's edges in document order
+	sort.Slice(docs, func(i, j int) bool { return bytes.Compare(docs[i].Id[:], docs[j].Id[:]) < 0 })
+	var models []mongo.WriteModel
+	for _, d := range docs {
+		if resolveLink(&d.PageLink) {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": d.Id}).
+				SetUpdate(bson.M{"$set": bson.M{"target_id": d.TargetId}}))
+		}
+		wb := byId[d.SourceId]
+		wb.Links = append(wb.Links, d.PageLink)
+	}
+	bulkWrite(ctx, linkColl, models, "resolving links")
+	return true
+}
+
+// resolveLink points an internal edge at the page it links to, or back at noPage once the page
+// it pointed at is gone, reporting whether the edge changed
+func resolveLink(l *PageLink) bool {
+	if l.Kind != linkInternal {
+		return false
+	}
+	old := l.TargetId
+	if old != noPage {
+		if _, ok := allWikibooksById[old]; ok {
+			return false
+		}
+		l.TargetId = noPage
+	}
+	if target, ok := lookupPagePath(editionsBySite[l.Site], l.TargetPath); ok {
+		l.TargetId = target.Id
+	}
+	return l.TargetId != old
+}
+
+// relinkStubs re-resolves the edges of unchanged pages in an incremental run that point at pages
+// added or deleted by the run, and rewrites those pages' links. Re-tokenized pages are resolved by
+// the final sweep.
+func relinkStubs(ctx context.Context, added, deleted []int) {
+	if len(added) == 0 && len(deleted) == 0 {
+		return
+	}
+	paths := make([]string, 0, 2*len(added))
+	for _, id := range added {
+		wb := allWikibooksById[id]
+		loc := editionsByLang[wb.Lang].pageLoc(wb.Url)
+		paths = append(paths, loc, strings.ReplaceAll(loc, " ", "_"))
+	}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"target_id": bson.M{"$in": append([]int{}, deleted...)}},
+		bson.M{"kind": linkInternal, "target_id": noPage, "target_path": bson.M{"$in": paths}},
+	}}
+	cur, err := linkColl.Find(ctx, filter, options.Find().SetProjection(bson.M{"source_id": 1}))
+	if err != nil {
+		err = errors.Wrap(err, "finding links to added and deleted pages")
+		log.Println(err)
+		return
+	}
+	var docs []linkDoc
+	if err = cur.All(ctx, &docs); err != nil {
+		err = errors.Wrap(err, "decoding links to added and deleted pages")
+		log.Println(err)
+		return
+	}
+	var stubs []*wikibook
+	seen := make(map[int]bool)
+	for _, d := range docs {
+		if wb := allWikibooksById[d.SourceId]; wb != nil && wb.stub && !seen[wb.Id] {
+			seen[wb.Id] = true
+			stubs = append(stubs, wb)
+		}
+	}
+	if len(stubs) > 0 {
+		log.Printf("incremental: re-resolving the links of %d unchanged pages", len(stubs))
+	}
+	for i := 0; i < len(stubs); i += writeBatchSize {
+		batch := stubs[i:minInt(i+writeBatchSize, len(stubs))]
+		if !resolveLinks(ctx, batch) {
+			continue
+		}
+		models := make([]mongo.WriteModel, len(batch))
+		for k, wb := range batch {
+			models[k] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": wb.Id}).
+				SetUpdate(bson.M{"$set": bson.M{"links": wb.Links}})
+			wb.Links = nil
+		}
+		bulkWrite(ctx, wbColl, models, "re-resolving links of unchanged pages")
+	}
+}
+
+// ensureLinkIndex indexes the links collection by source page, which resolveLinks and incremental
+// runs look edges up by
+func ensureLinkIndex(ctx context.Context) {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "source_id", Value: 1}}}
+	if _, err := linkColl.Indexes().CreateOne(ctx, model); err != nil {
+		err = errors.Wrap(err, "indexing links by source")
+		log.Fatal(err)
+	}
+}
+
+// lookupPagePath finds a page of an edition by location, tolerating percent-encoding and spaces for underscores
+func lookupPagePath(ed *edition, p string) (*wikibook, bool) {
+	if wb, ok := allWikibooksByPath[ed.pathKey(p)]; ok {
+		return wb, true
+	}
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		if wb, ok := allWikibooksByPath[ed.pathKey(unescaped)]; ok {
+			return wb, true
+		}
+		if wb, ok := allWikibooksByPath[ed.pathKey(strings.ReplaceAll(unescaped, "_", " "))]; ok {
+			return wb, true
+		}
+	}
+	return nil, false
+}
+
+// writeLinks stores the edges of wbs as parsed, replacing their previous entries in incremental
+// runs; resolveLinks fills in the target ids later
+func writeLinks(ctx context.Context, wbs []*wikibook, replace bool) {
+	if replace && len(wbs) > 0 {
+		ids := make([]int, len(wbs))
+		for i, wb := range wbs {
+			ids[i] = wb.Id
+		}
+		if _, err := linkColl.DeleteMany(ctx, bson.M{"source_id": bson.M{"$in": ids}}); err != nil {
+			err = errors.Wrap(err, "deleting stale links")
+			log.Println(err)
+		}
+	}
+	docs := make([]interface{}, 0, writeBatchSize)
+	for _, wb := range wbs {
+		for _, l := range wb.Links {
+			docs = append(docs, &linkDoc{SourceId: wb.Id, PageLink: l})
+			if len(docs) == writeBatchSize {
+				insertLinks(ctx, docs)
+				docs = docs[:0]
+			}
+		}
+	}
+	insertLinks(ctx, docs)
+}
+
+func insertLinks(ctx context.Context, docs []interface{}) {
+	if len(docs) == 0 {
+		return
+	}
+	if _, err := linkColl.InsertMany(ctx, docs); err != nil {
+		err = errors.Wrap(err, "inserting links")
+		log.Println(err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLinkDocInlinesLink(t *testing.T) {
+	b, err := bson.Marshal(&linkDoc{SourceId: 3, PageLink: PageLink{
+		Kind: linkExternal, TargetId: noPage, Domain: "x.org", Url: "http://x.org", Text: "x",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err = bson.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"source_id", "kind", "target_id", "domain", "url", "text"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("marshalled linkDoc has no %s: %v", key, got)
+		}
+	}
+}
+
+func TestExtractLinksResolvesAgainstPage(t *testing.T) {
+	wiki := &edition{Lang: "en", BaseUrl: "https://en.wikibooks.org/wiki/", Site: "en.wikibooks.org", Source: "sqlite"}
+	docs := &edition{Lang: "docs", BaseUrl: "https://docs.example.com/", Site: "docs.example.com", Source: "dir"}
+	editionsBySite = map[string]*edition{wiki.Site: wiki, docs.Site: docs}
+
+	tests := []struct {
+		pageUrl, href, want string
+	}{
+		{"https://en.wikibooks.org/wiki/Cookbook/Bread", "/wiki/Cookbook/Flour", "Cookbook/Flour"},
+		{"https://en.wikibooks.org/wiki/Cookbook/Bread", "Yeast", "Cookbook/Yeast"},
+		{"https://docs.example.com/guide/index.md", "install.md", "guide/install"},
+		{"https://docs.example.com/guide/install.md", "config.html#options", "guide/config"},
+		{"https://docs.example.com/guide/install.md", "../api/index.html", "api"},
+		{"https://docs.example.com/guide/install.md", "./", "guide"},
+		{"https://docs.example.com/guide/install.md", "v1.2", "guide/v1.2"},
+	}
+	for _, tt := range tests {
+		links := extractLinks(`<a href="`+tt.href+`">x</a>`, tt.pageUrl)
+		if len(links) != 1 || links[0].Kind != linkInternal || links[0].TargetPath != tt.want {
+			t.Errorf("link %q on %s gave %+v, want internal link to %q", tt.href, tt.pageUrl, links, tt.want)
+		}
+	}
+}
+
+func TestResolveLinkFollowsAddedAndDeletedPages(t *testing.T) {
+	ed := &edition{Lang: "en", BaseUrl: "https://en.wikibooks.org/wiki/", Site: "en.wikibooks.org"}
+	editionsByLang = map[string]*edition{"en": ed}
+	editionsBySite = map[string]*edition{ed.Site: ed}
+	resetPages()
+	registerStub(ed, 1, ed.BaseUrl+"Cookbook")
+	registerWikibook(&wikibook{Id: 9, Url: ed.BaseUrl + "Cookbook/Yeast", Lang: "en", ParentPageId: noPage})
+
+	tests := []struct {
+		link    PageLink
+		want    int
+		changed bool
+	}{
+		{PageLink{Kind: linkInternal, TargetId: 1, TargetPath: "Cookbook", Site: ed.Site}, 1, false},
+		{PageLink{Kind: linkInternal, TargetId: noPage, TargetPath: "Cookbook/Yeast", Site: ed.Site}, 9, true},      // added
+		{PageLink{Kind: linkInternal, TargetId: 4, TargetPath: "Cookbook/Flour", Site: ed.Site}, noPage, true},      // deleted
+		{PageLink{Kind: linkInternal, TargetId: noPage, TargetPath: "Cookbook/Salt", Site: ed.Site}, noPage, false}, // still missing
+		{PageLink{Kind: linkExternal, TargetId: noPage, Domain: "x.org"}, noPage, false},
+	}
+	for _, tt := range tests {
+		l := tt.link
+		if changed := resolveLink(&l); changed != tt.changed || l.TargetId != tt.want {
+			t.Errorf("resolveLink(%+v) = %v with target %d, want %v with %d", tt.link, changed, l.TargetId, tt.changed, tt.want)
+		}
+	}
+}
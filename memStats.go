@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// memSampler polls the runtime for the largest heap seen during the run
+type memSampler struct {
+	peakHeap uint64
+	peakSys  uint64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func startMemSampler(interval time.Duration) *memSampler {
+	s := &memSampler{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			s.sample()
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return s
+}
+
+func (s *memSampler) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapInuse > atomic.LoadUint64(&s.peakHeap) {
+		atomic.StoreUint64(&s.peakHeap, ms.HeapInuse)
+	}
+	if ms.Sys > atomic.LoadUint64(&s.peakSys) {
+		atomic.StoreUint64(&s.peakSys, ms.Sys)
+	}
+}
+
+// report stops sampling and logs the peaks
+func (s *memSampler) report() {
+	close(s.stop)
+	<-s.done
+	s.sample()
+	log.Printf("peak memory: heap in use %.1f MiB, obtained from OS %.1f MiB",
+		float64(atomic.LoadUint64(&s.peakHeap))/(1<<20), float64(atomic.LoadUint64(&s.peakSys))/(1<<20))
+}
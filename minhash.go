@@ -0,0 +1,180 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	minhashSize  = 128 // hash functions per signature
+	minhashBands = 16  // LSH bands of minhashSize/minhashBands rows each
+)
+
+var (
+	// dedupe enables near-duplicate detection, dedupeExclude also drops non-canonical pages from the token index
+	dedupe          = envBool("DEDUPE")
+	dedupeExclude   = envBool("DEDUPE_EXCLUDE")
+	dedupeThreshold = 0.9 // minimum estimated jaccard similarity of two token sets to count as duplicates
+	// dedupeMinTokens is the smallest token set that gets a signature; emptier pages such as stubs
+	// would otherwise all match each other
+	dedupeMinTokens = 5
+)
+
+func init() {
+	if f, err := strconv.ParseFloat(envOr("DEDUPE_THRESHOLD", ""), 64); err == nil && f > 0 && f <= 1 {
+		dedupeThreshold = f
+	}
+	if n, err := strconv.Atoi(envOr("DEDUPE_MIN_TOKENS", "")); err == nil && n > 0 {
+		dedupeMinTokens = n
+	}
+}
+
+// minhashSignature summarizes a page's token set so that the share of equal positions
+// between two signatures estimates the jaccard similarity of the sets. Sets smaller than
+// dedupeMinTokens get no signature and are never grouped.
+func minhashSignature(tkns map[string]int) []uint32 {
+	if len(tkns) < dedupeMinTokens {
+		return nil
+	}
+	sig := make([]uint32, minhashSize)
+	for i := range sig {
+		sig[i] = math.MaxUint32
+	}
+	for t := range tkns {
+		h := fnv.New64a()
+		h.Write([]byte(t))
+		x := h.Sum64()
+		for i := range sig {
+			if v := uint32(splitmix64(x + uint64(i)*0x9e3779b97f4a7c15)); v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func signatureSimilarity(a, b []uint32) float64 {
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+// detectDuplicates groups pages with near-identical token sets using locality sensitive hashing over
+// their minhash signatures and points each page's CanonicalId at the lowest id in its group
+func detectDuplicates(wbs []*wikibook) {
+	var candidates []*wikibook
+	for _, wb := range wbs {
+		if len(wb.minhash) > 0 {
+			candidates = append(candidates, wb)
+		}
+	}
+
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	rows := minhashSize / minhashBands
+	for band := 0; band < minhashBands; band++ {
+		buckets := make(map[uint64][]int)
+		for i, wb := range candidates {
+			h := fnv.New64a()
+			for _, v := range wb.minhash[band*rows : (band+1)*rows] {
+				h.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+			}
+			buckets[h.Sum64()] = append(buckets[h.Sum64()], i)
+		}
+		for _, bucket := range buckets {
+			for x := 0; x < len(bucket); x++ {
+				for y := x + 1; y < len(bucket); y++ {
+					a, b := find(bucket[x]), find(bucket[y])
+					if a == b {
+						continue
+					}
+					if signatureSimilarity(candidates[bucket[x]].minhash, candidates[bucket[y]].minhash) >= dedupeThreshold {
+						parent[b] = a
+					}
+				}
+			}
+		}
+	}
+
+	groups := make(map[int][]*wikibook)
+	for i, wb := range candidates {
+		root := find(i)
+		groups[root] = append(groups[root], wb)
+	}
+	dupGroups, dupPages := 0, 0
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].Id < group[j].Id })
+		for _, wb := range group {
+			wb.CanonicalId = group[0].Id
+		}
+		dupGroups++
+		dupPages += len(group) - 1
+	}
+	log.Printf("dedupe: %d duplicate groups, %d non-canonical pages", dupGroups, dupPages)
+
+	if dedupeExclude {
+		excludeDuplicates(wbs)
+	}
+}
+
+// excludeDuplicates removes non-canonical pages from the token references, dropping tokens that only they used,
+// and from the phrase counts
+func excludeDuplicates(wbs []*wikibook) {
+	for _, wb := range wbs {
+		if wb.CanonicalId == wb.Id {
+			continue
+		}
+		if phraseMaxLen > 1 {
+			uncountPhrases(wb)
+		}
+		for key := range wb.tknQtyMap {
+			refs := tokenRefs[key]
+			delete(refs, wb.Id)
+			if len(refs) == 0 {
+				delete(tokenRefs, key)
+				allTokensMap.Delete(key)
+			}
+		}
+	}
+}
+
+// indexedPages returns the pages that belong in the token vectors
+func indexedPages(wbs []*wikibook) []*wikibook {
+	if !dedupeExclude {
+		return wbs
+	}
+	indexed := make([]*wikibook, 0, len(wbs))
+	for _, wb := range wbs {
+		if wb.CanonicalId == wb.Id {
+			indexed = append(indexed, wb)
+		}
+	}
+	return indexed
+}
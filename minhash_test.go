@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func minhashPage(id int, words ...string) *wikibook {
+	wb := &wikibook{Id: id, CanonicalId: id, tknQtyMap: make(map[string]int)}
+	for _, w := range words {
+		wb.tknQtyMap[tokenKey("en", w)]++
+	}
+	wb.minhash = minhashSignature(wb.tknQtyMap)
+	return wb
+}
+
+func manyWords(prefix string, n int) []string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = prefix + strconv.Itoa(i)
+	}
+	return words
+}
+
+func TestDetectDuplicates(t *testing.T) {
+	shared := manyWords("w", 40)
+	wbs := []*wikibook{
+		minhashPage(0, shared...),
+		minhashPage(1),
+		minhashPage(2),
+		minhashPage(3, "a", "b"),
+		minhashPage(4, "c", "d"),
+		minhashPage(5, manyWords("x", 40)...),
+		minhashPage(6, shared...),
+		minhashPage(7, append(shared, "extra")...),
+	}
+	detectDuplicates(wbs)
+
+	want := []int{0, 1, 2, 3, 4, 5, 0, 0}
+	for i, wb := range wbs {
+		if wb.CanonicalId != want[i] {
+			t.Errorf("page %d: canonical id %d, want %d", wb.Id, wb.CanonicalId, want[i])
+		}
+	}
+}
+
+func TestMinhashSignatureSimilarity(t *testing.T) {
+	a := minhashSignature(minhashPage(0, manyWords("w", 100)...).tknQtyMap)
+	b := minhashSignature(minhashPage(1, append(manyWords("w", 100)[:50], manyWords("v", 50)...)...).tknQtyMap)
+	// jaccard similarity of the two sets is 50/150
+	if sim := signatureSimilarity(a, b); sim < 0.2 || sim > 0.45 {
+		t.Errorf("similarity %.2f, want about 0.33", sim)
+	}
+}
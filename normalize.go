@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	joinerSplit = "split" // treat the character as a word boundary: "don't" -> "don", "t"
+	joinerKeep  = "keep"  // keep it inside the word: "don't", "state-of-the-art"
+	joinerDrop  = "drop"  // remove it and join the parts, as the ascii splitter does: "dont", "stateoftheart"
+)
+
+// foldSpecials are letters that do not decompose into a base letter and combining marks
+var foldSpecials = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "Æ", "AE", "œ", "oe", "Œ", "OE", "ø", "o", "Ø", "O",
+	"ł", "l", "Ł", "L", "đ", "d", "Đ", "D", "ð", "d", "Ð", "D", "þ", "th", "Þ", "TH", "ı", "i",
+)
+
+type (
+	// unicodeSplitter segments text on unicode word boundaries: runs of letters, digits, marks and
+	// connector punctuation form words, with apostrophes and hyphens between letters handled per mode
+	// and '.' or ',' between digits kept so numbers like 3.14 stay whole
+	unicodeSplitter struct {
+		apostrophes string
+		hyphens     string
+	}
+	// foldNormalizer strips diacritics, "café" -> "cafe"
+	foldNormalizer struct {
+		t transform.Transformer
+	}
+	// tokenReport compares a run's tokenizer against the default ascii chain
+	tokenReport struct {
+		path     string
+		baseline map[string]Tokenizer
+		old      map[string]int
+		new      map[string]int
+		oldTotal int
+		newTotal int
+	}
+)
+
+func init() {
+	tokenStages["unicode"] = func(ed *edition) (interface{}, error) {
+		return unicodeSplitter{apostrophes: ed.apostrophes, hyphens: ed.hyphens}, nil
+	}
+	tokenStages["fold"] = func(*edition) (interface{}, error) { return newFoldNormalizer(), nil }
+}
+
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’' || r == 'ʼ' || r == '′'
+}
+
+func isHyphen(r rune) bool {
+	return r == '-' || r == '‐' || r == '‑'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Pc)
+}
+
+func (s unicodeSplitter) Split(text string) []token {
+	var (
+		tkns  []token
+		b     strings.Builder
+		prev  rune
+		start int
+	)
+	flush := func() {
+		if b.Len() > 0 {
+			tkns = append(tkns, token{Text: b.String(), Pos: len(tkns), Offset: start})
+			b.Reset()
+		}
+	}
+	for i, size := 0, 0; i < len(text); i += size {
+		// the decoded width, unlike utf8.RuneLen, is right for invalid bytes decoded as RuneError
+		var r rune
+		r, size = utf8.DecodeRuneInString(text[i:])
+		next, _ := utf8.DecodeRuneInString(text[i+size:])
+		inWord := b.Len() > 0 && unicode.IsLetter(prev) && unicode.IsLetter(next)
+		switch {
+		case isWordRune(r):
+			if b.Len() == 0 {
+				start = i
+			}
+			b.WriteRune(r)
+		case inWord && isApostrophe(r):
+			s.join(&b, s.apostrophes, '\'', flush)
+		case inWord && isHyphen(r):
+			s.join(&b, s.hyphens, '-', flush)
+		case (r == '.' || r == ',') && b.Len() > 0 && unicode.IsDigit(prev) && unicode.IsDigit(next):
+			b.WriteRune(r)
+		default:
+			flush()
+		}
+		prev = r
+	}
+	flush()
+	return tkns
+}
+
+func (s unicodeSplitter) join(b *strings.Builder, mode string, r rune, flush func()) {
+	switch mode {
+	case joinerKeep:
+		b.WriteRune(r)
+	case joinerDrop:
+	default:
+		flush()
+	}
+}
+
+func newFoldNormalizer() foldNormalizer {
+	return foldNormalizer{t: transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)}
+}
+
+func (f foldNormalizer) Normalize(tkn string) string {
+	folded, _, err := transform.String(f.t, foldSpecials.Replace(tkn))
+	if err != nil {
+		return tkn
+	}
+	return folded
+}
+
+// joinerMode reads key, TOKEN_APOSTROPHES or TOKEN_HYPHENS, for one edition, rejecting unknown modes
+func joinerMode(key, lang string) (string, error) {
+	v := editionEnv(key, lang, joinerSplit)
+	if v != joinerSplit && v != joinerKeep && v != joinerDrop {
+		return "", errors.Errorf("%s must be %s, %s or %s, not %q", key, joinerSplit, joinerKeep, joinerDrop, v)
+	}
+	return v, nil
+}
+
+// newTokenReport prepares a comparison against the default chain, written to path at the end of the run
+func newTokenReport(path string) *tokenReport {
+	r := &tokenReport{
+		path:     path,
+		baseline: make(map[string]Tokenizer),
+		old:      make(map[string]int),
+		new:      make(map[string]int),
+	}
+	for _, ed := range editions {
+		c, err := newTokenChain(ed, defaultTokenChain)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "building baseline tokenizer"))
+		}
+		r.baseline[ed.Lang] = c
+	}
+	return r
+}
+
+// add counts a page's tokens under both the configured and the baseline tokenizer
+func (r *tokenReport) add(lang, text string, tkns []token) {
+	for _, t := range tkns {
+		r.new[tokenKey(lang, t.Text)]++
+	}
+	r.newTotal += len(tkns)
+	old := r.baseline[lang].Tokenize(text)
+	for _, t := range old {
+		r.old[tokenKey(lang, t.Text)]++
+	}
+	r.oldTotal += len(old)
+}
+
+// write logs a summary and writes the tokens gained and lost relative to the baseline, most frequent first
+func (r *tokenReport) write() {
+	gained, lost := reportDiff(r.new, r.old), reportDiff(r.old, r.new)
+	summary := fmt.Sprintf("token report: baseline %d tokens, %d unique; this run %d tokens, %d unique; %d unique gained, %d lost",
+		r.oldTotal, len(r.old), r.newTotal, len(r.new), len(gained), len(lost))
+	log.Println(summary)
+
+	var b strings.Builder
+	b.WriteString(summary + "\n\ngained (count this run, baseline count):\n")
+	for _, k := range gained {
+		fmt.Fprintf(&b, "%s\t%d\t%d\n", k, r.new[k], r.old[k])
+	}
+	b.WriteString("\nlost (baseline count, count this run):\n")
+	for _, k := range lost {
+		fmt.Fprintf(&b, "%s\t%d\t%d\n", k, r.old[k], r.new[k])
+	}
+	if err := os.WriteFile(r.path, []byte(b.String()), 0644); err != nil {
+		log.Println(errors.Wrap(err, "writing token report"))
+	}
+}
+
+// reportDiff returns the keys of a missing from b, by descending count in a
+func reportDiff(a, b map[string]int) []string {
+	var keys []string
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if a[keys[i]] != a[keys[j]] {
+			return a[keys[i]] > a[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnicodeSplitter(t *testing.T) {
+	s := unicodeSplitter{apostrophes: joinerDrop, hyphens: joinerKeep}
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Don't use well-known π ≈ 3.14, ok?", []string{"Dont", "use", "well-known", "π", "3.14", "ok"}},
+		{"café naïve", []string{"café", "naïve"}},
+		// invalid bytes decode as RuneError one byte at a time and end words; the last one used to
+		// slice past the end of the text
+		{"ab\xffcd", []string{"ab", "cd"}},
+		{"word\xff", []string{"word"}},
+		{"\xe2\x82", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, tkn := range s.Split(tt.text) {
+			got = append(got, tkn.Text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Split(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFoldNormalizer(t *testing.T) {
+	f := newFoldNormalizer()
+	tests := map[string]string{
+		"café":     "cafe",
+		"naïve":    "naive",
+		"Ångström": "Angstrom",
+		"straße":   "strasse",
+		"Łódź":     "Lodz",
+		"plain":    "plain",
+	}
+	for in, want := range tests {
+		if got := f.Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestJoinerModesPerEdition(t *testing.T) {
+	t.Setenv("TOKENIZER", "unicode,lower")
+	t.Setenv("TOKEN_HYPHENS", joinerKeep)
+	t.Setenv("TOKEN_HYPHENS_DE", joinerDrop)
+	t.Setenv("ETL_SOURCE", "none")
+	for lang, want := range map[string][]string{
+		"en": {"well-known"},
+		"de": {"wellknown"},
+	} {
+		ed, err := newEdition(lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, tkn := range ed.tokenizer.Tokenize("Well-known") {
+			got = append(got, tkn.Text)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", lang, got, want)
+		}
+	}
+
+	t.Setenv("TOKEN_APOSTROPHES_DE", "join")
+	if _, err := newEdition("de"); err == nil {
+		t.Error("unknown TOKEN_APOSTROPHES_DE was accepted")
+	}
+}
+
+func TestTokenReport(t *testing.T) {
+	dict := filepath.Join(t.TempDir(), "dict")
+	if err := os.WriteFile(dict, []byte("wellknown\ngarden\ntools\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DICTIONARY_PATH", dict)
+	t.Setenv("TOKENIZER", "unicode,lower,fold")
+	t.Setenv("TOKEN_HYPHENS", joinerKeep)
+	ed := testEdition(t, "en")
+	path := filepath.Join(t.TempDir(), "report.txt")
+	r := newTokenReport(path)
+
+	// the baseline ascii chain joins the hyphenated word and keeps only dictionary words
+	text := "Well-known tools. Well-known café garden."
+	r.add(ed.Lang, text, ed.tokenizer.Tokenize(text))
+	if r.newTotal != 5 || r.oldTotal != 4 {
+		t.Errorf("totals = %d, %d, want 5, 4", r.newTotal, r.oldTotal)
+	}
+	if got, want := reportDiff(r.new, r.old), []string{"en:well-known", "en:cafe"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gained = %q, want %q", got, want)
+	}
+	if got, want := reportDiff(r.old, r.new), []string{"en:wellknown"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lost = %q, want %q", got, want)
+	}
+
+	r.write()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"en:well-known\t2\t0", "en:cafe\t1\t0", "en:wellknown\t2\t0"} {
+		if !strings.Contains(string(b), line+"\n") {
+			t.Errorf("report is missing %q:\n%s", line, b)
+		}
+	}
+}
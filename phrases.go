@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	// phraseMaxLen enables phrase tokens of 2 up to phraseMaxLen adjacent words when at least 2.
+	// A phrase is admitted once it occurs phraseMinCount times in its edition with a pointwise
+	// mutual information of at least phraseMinPMI bits.
+	phraseMaxLen   = 0
+	phraseMinCount = 5
+	phraseMinPMI   = math.Inf(-1)
+
+	phraseCounts = make(map[string]int) // corpus occurrences of candidate phrases, by token key
+	wordCounts   = make(map[string]int) // corpus occurrences of single tokens, by token key
+	langCounts   = make(map[string]int) // tokens read per edition
+)
+
+func init() {
+	if n, err := strconv.Atoi(envOr("PHRASES", "")); err == nil && n >= 2 {
+		phraseMaxLen = minInt(n, 3)
+	}
+	if n, err := strconv.Atoi(envOr("PHRASE_MIN_COUNT", "")); err == nil && n > 0 {
+		phraseMinCount = n
+	}
+	if f, err := strconv.ParseFloat(envOr("PHRASE_MIN_PMI", ""), 64); err == nil {
+		phraseMinPMI = f
+	}
+}
+
+// collectPhrases counts the candidate phrases in one field of a page, runs of words that were adjacent
+// in the text with nothing filtered out between them, into qty and the corpus counts. With occ set,
+// the first word of each phrase occurrence is added to it for the phrase's positions.
+func collectPhrases(lang string, f int, tkns []token, qty map[string]fieldQty, occ map[string][]token) {
+	langCounts[lang] += len(tkns)
+	for i, t := range tkns {
+		wordCounts[tokenKey(lang, t.Text)]++
+		for n := 2; n <= phraseMaxLen && i+n <= len(tkns); n++ {
+			if tkns[i+n-1].Pos != t.Pos+n-1 {
+				break
+			}
+			words := make([]string, n)
+			for j := range words {
+				words[j] = tkns[i+j].Text
+			}
+			key := tokenKey(lang, strings.Join(words, " "))
+			q := qty[key]
+			q.add(f, 1)
+			qty[key] = q
+			phraseCounts[key]++
+			if occ != nil {
+				occ[key] = append(occ[key], t)
+			}
+		}
+	}
+}
+
+// phrasePMI is log2 of how much more often the phrase occurs than its words would by chance
+func phrasePMI(key string, count int) float64 {
+	lang, phrase := splitTokenKey(key)
+	total := float64(langCounts[lang])
+	pmi := math.Log2(float64(count) / total)
+	for _, w := range strings.Split(phrase, " ") {
+		pmi -= math.Log2(float64(wordCounts[tokenKey(lang, w)]) / total)
+	}
+	return pmi
+}
+
+// admitPhrases adds the phrases passing the thresholds to the token counts of the pages they occur
+// on, once the whole corpus has been counted. Phrases with a word that is no longer a token, after
+// pruneVocabulary, are left out, and so are duplicates excluded from the index under DEDUPE_EXCLUDE.
+func admitPhrases(wbs []*wikibook) {
+	admitted := make(map[string]bool)
+	for key, c := range phraseCounts {
+		if c >= phraseMinCount && phraseWordsKept(key) && phrasePMI(key, c) >= phraseMinPMI {
+			admitted[key] = true
+		}
+	}
+	log.Printf("phrases: %d of %d candidates admitted", len(admitted), len(phraseCounts))
+
+	for _, wb := range wbs {
+		if dedupeExclude && wb.CanonicalId != wb.Id {
+			wb.phraseQty = nil
+			continue
+		}
+		changed := false
+		for key, q := range wb.phraseQty {
+			if !admitted[key] {
+				continue
+			}
+			wb.tknQtyMap[key] = q.weighted()
+			wb.fieldQtys[key] = q
+			allTokensMap.Put(key, true)
+			if m, ok := tokenRefs[key]; ok {
+				m[wb.Id] = true
+			} else {
+				tokenRefs[key] = map[int]bool{wb.Id: true}
+			}
+			changed = true
+		}
+		wb.phraseQty = nil
+		if changed {
+			wb.recount()
+		}
+	}
+	phraseCounts, wordCounts, langCounts = nil, nil, nil
+}
+
+// uncountPhrases takes a page out of the corpus counts, for duplicates excluded from the index
+func uncountPhrases(wb *wikibook) {
+	lang := wb.tokenLang()
+	for key, q := range wb.phraseQty {
+		phraseCounts[key] -= q.Title + q.Abstract + q.Body
+	}
+	for key, q := range wb.fieldQtys {
+		n := q.Title + q.Abstract + q.Body
+		wordCounts[key] -= n
+		langCounts[lang] -= n
+	}
+}
+
+func phraseWordsKept(key string) bool {
+	lang, phrase := splitTokenKey(key)
+	for _, w := range strings.Split(phrase, " ") {
+		if _, ok := tokenRefs[tokenKey(lang, w)]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+// phrasePage counts the phrases of body like parseDoc does, without a tokenizer
+func phrasePage(id, canonical int, body ...string) *wikibook {
+	wb := &wikibook{Id: id, Lang: "en", CanonicalId: canonical,
+		tknQtyMap: make(map[string]int), fieldQtys: make(map[string]fieldQty), phraseQty: make(map[string]fieldQty)}
+	tkns := make([]token, len(body))
+	for i, w := range body {
+		tkns[i] = token{Text: w, Pos: i}
+		key := tokenKey("en", w)
+		q := wb.fieldQtys[key]
+		q.add(fieldBody, 1)
+		wb.fieldQtys[key] = q
+		wb.tknQtyMap[key] = q.weighted()
+		if tokenRefs[key] == nil {
+			tokenRefs[key] = make(map[int]bool)
+		}
+		tokenRefs[key][id] = true
+	}
+	collectPhrases("en", fieldBody, tkns, wb.phraseQty, nil)
+	return wb
+}
+
+func TestAdmitPhrasesSkipsExcludedDuplicates(t *testing.T) {
+	defer func(n, c int, ex bool) { phraseMaxLen, phraseMinCount, dedupeExclude = n, c, ex }(phraseMaxLen, phraseMinCount, dedupeExclude)
+	phraseMaxLen, phraseMinCount, dedupeExclude = 2, 2, true
+	phraseCounts, wordCounts, langCounts = make(map[string]int), make(map[string]int), make(map[string]int)
+	tokenRefs, allTokensMap = make(map[string]map[int]bool), NewConcurrentMap()
+
+	wbs := []*wikibook{
+		phrasePage(0, 0, "linked", "list", "binary", "tree"),
+		phrasePage(1, 1, "linked", "list"),
+		phrasePage(2, 0, "binary", "tree"),
+	}
+	excludeDuplicates(wbs)
+	admitPhrases(wbs)
+
+	if tokenRefs[tokenKey("en", "linked list")] == nil {
+		t.Error("linked list, on two canonical pages, was not admitted")
+	}
+	if refs := tokenRefs[tokenKey("en", "binary tree")]; refs != nil {
+		t.Errorf("binary tree, on one canonical page and its duplicate, was admitted with refs %v", refs)
+	}
+	if _, ok := wbs[2].tknQtyMap[tokenKey("en", "linked list")]; ok || wbs[2].tokensChanged {
+		t.Error("the excluded duplicate got phrase tokens")
+	}
+}
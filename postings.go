@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type (
+	// posting is where a token occurs in a page body, as delta encoded word positions and, optionally,
+	// byte offsets into BodyText; see encodeDeltas
+	posting struct {
+		positions []byte
+		offsets   []byte
+	}
+	// postingDoc is a postings collection entry. Postings are kept out of the tokens collection so
+	// that a common token's references do not carry every page's positions past mongodb's 16MB limit;
+	// a single page's positions are bounded by maxBodyBytes.
+	postingDoc struct {
+		Id        postingKey `bson:"_id"`
+		Positions []byte     `bson:"positions"`         // delta varint word positions in the body, see encodeDeltas
+		Offsets   []byte     `bson:"offsets,omitempty"` // delta varint byte offsets into body_text
+	}
+	postingKey struct {
+		Token int `bson:"token"`
+		Page  int `bson:"page"`
+	}
+)
+
+var (
+	// postingPositions writes token positions to the postings collection, postingOffsets
+	// also byte offsets. Positions count words in the text before filtering, so adjacent words differ by one.
+	postingPositions = envBool("POSITIONS")
+	postingOffsets   = envBool("POSITION_OFFSETS")
+)
+
+func init() {
+	if postingOffsets && textSource == textHtml {
+		log.Println("POSITION_OFFSETS is ignored with TEXT_SOURCE=html, the extracted text is not stored")
+		postingOffsets = false
+	}
+	postingPositions = postingPositions || postingOffsets
+}
+
+// encodeDeltas packs ascending ints as unsigned varints of the gap to the previous value,
+// the first one counted from zero, e.g. 3, 10, 11 becomes 3, 7, 1
+func encodeDeltas(vals []int) []byte {
+	buf := make([]byte, 0, len(vals)*2)
+	var tmp [binary.MaxVarintLen64]byte
+	prev := 0
+	for _, v := range vals {
+		n := binary.PutUvarint(tmp[:], uint64(v-prev))
+		buf = append(buf, tmp[:n]...)
+		prev = v
+	}
+	return buf
+}
+
+// encodePostings encodes the occurrences of each token key, listed in text order
+func encodePostings(occ map[string][]token) map[string]posting {
+	out := make(map[string]posting, len(occ))
+	for key, tkns := range occ {
+		pos := make([]int, len(tkns))
+		for i, t := range tkns {
+			pos[i] = t.Pos
+		}
+		p := posting{positions: encodeDeltas(pos)}
+		if postingOffsets {
+			for i, t := range tkns {
+				pos[i] = t.Offset
+			}
+			p.offsets = encodeDeltas(pos)
+		}
+		out[key] = p
+	}
+	return out
+}
+
+// reference is the tokens collection entry for page id under token key
+func reference(key string, id int) idQty {
+	wb := allWikibooksById[id]
+	return idQty{Id: id, Qty: wb.tknQtyMap[key], Fields: wb.fieldQtys[key]}
+}
+
+// buildPostingDocs builds the postings collection entries of a staged page, for the tokens
+// that still reference the page after the post-read passes
+func buildPostingDocs(st *pageStage) []interface{} {
+	var docs []interface{}
+	for _, p := range st.Postings {
+		id, ok := tokenIds[p.Key]
+		if !ok || !tokenRefs[p.Key][st.Id] {
+			continue
+		}
+		docs = append(docs, &postingDoc{Id: postingKey{Token: id, Page: st.Id}, Positions: p.Positions, Offsets: p.Offsets})
+	}
+	return docs
+}
+
+// writePostings inserts the postings of the staged pages in batches, once token ids are assigned
+func writePostings(ctx context.Context) {
+	docs := make([]interface{}, 0, writeBatchSize)
+	insert := func() {
+		if len(docs) == 0 {
+			return
+		}
+		if _, err := postingColl.InsertMany(ctx, docs); err != nil {
+			err = errors.Wrap(err, "inserting postings")
+			log.Println(err)
+		}
+		docs = docs[:0]
+	}
+	eachStaged(ctx, func(st *pageStage) {
+		for _, d := range buildPostingDocs(st) {
+			if docs = append(docs, d); len(docs) == writeBatchSize {
+				insert()
+			}
+		}
+	})
+	insert()
+}
+
+// deletePostings removes the postings of pages ids
+func deletePostings(ctx context.Context, ids []int) {
+	if _, err := postingColl.DeleteMany(ctx, bson.M{"_id.page": bson.M{"$in": ids}}); err != nil {
+		log.Println(errors.Wrap(err, "deleting stale postings"))
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxBsonSize is mongodb's document size limit
+const maxBsonSize = 16 << 20
+
+func TestPostingsStayUnderDocumentLimit(t *testing.T) {
+	defer func(o bool) { postingOffsets = o }(postingOffsets)
+	postingOffsets = true
+	allWikibooksById, tokenRefs = make(map[int]*wikibook), make(map[string]map[int]bool)
+	key := tokenKey("en", "the")
+	tokenIds = map[string]int{key: 0}
+	tokenRefs[key] = make(map[int]bool)
+
+	// 20000 pages using the token 2000 times each would put about 100MB of postings in one token document
+	occ := make([]token, 2000)
+	for i := range occ {
+		occ[i] = token{Text: "the", Pos: i * 200, Offset: i * 1000}
+	}
+	var staged []pageStage
+	for id := 0; id < 20000; id++ {
+		wb := &wikibook{Id: id,
+			tknQtyMap: map[string]int{key: len(occ)},
+			fieldQtys: map[string]fieldQty{key: {Body: len(occ)}},
+			postings:  encodePostings(map[string][]token{key: occ}),
+		}
+		allWikibooksById[id] = wb
+		tokenRefs[key][id] = true
+		st, ok := newPageStage(wb)
+		if !ok {
+			t.Fatalf("page %d has nothing staged", id)
+		}
+		staged = append(staged, st)
+	}
+
+	tkDoc, err := bson.Marshal(buildTokenDocs([]string{key})[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tkDoc) > maxBsonSize {
+		t.Errorf("token document is %d bytes", len(tkDoc))
+	}
+	var docs []interface{}
+	for i := range staged {
+		docs = append(docs, buildPostingDocs(&staged[i])...)
+	}
+	if len(docs) != 20000 {
+		t.Fatalf("got %d posting documents, want 20000", len(docs))
+	}
+	total := 0
+	for _, d := range docs {
+		b, err := bson.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > maxBsonSize {
+			t.Fatalf("posting document is %d bytes", len(b))
+		}
+		total += len(b)
+	}
+	if total <= maxBsonSize {
+		t.Errorf("postings total %d bytes, the case is too small to exceed the limit inline", total)
+	}
+}
+
+func TestBuildPostingDocsSkipsDroppedTokens(t *testing.T) {
+	kept, dropped := tokenKey("en", "tree"), tokenKey("en", "the")
+	tokenIds = map[string]int{kept: 7}
+	tokenRefs = map[string]map[int]bool{kept: {1: true}}
+	st := &pageStage{Id: 1, Postings: []stagedPosting{{Key: kept, Positions: []byte{3}}, {Key: dropped, Positions: []byte{1}}}}
+	docs := buildPostingDocs(st)
+	if len(docs) != 1 || docs[0].(*postingDoc).Id != (postingKey{Token: 7, Page: 1}) {
+		t.Errorf("got postings %+v, want only token 7 on page 1", docs)
+	}
+}
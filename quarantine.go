@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	reasonReadError     = "read_error"
+	reasonBadUrl        = "bad_url"
+	reasonEmptyBody     = "empty_body"
+	reasonOversizedBody = "oversized_body"
+	reasonBadEncoding   = "bad_encoding"
+	reasonDuplicateUrl  = "duplicate_url"
+)
+
+// maxBodyBytes caps BodyText plus BodyHtml so page documents stay under mongodb's 16MB limit
+var maxBodyBytes = 8 << 20
+
+type (
+	// rowError is a failure confined to a single source record; the run quarantines it and moves on
+	rowError struct {
+		Url    string
+		Reason string // quarantine reason, reasonReadError unless the source knows better
+		err    error
+	}
+	// quarantine appends rejected records to a JSON lines file and tallies them by reason
+	quarantine struct {
+		f      *os.File
+		enc    *json.Encoder
+		counts map[string]int
+	}
+	quarantineEntry struct {
+		Url    string    `json:"url"`
+		Title  string    `json:"title,omitempty"`
+		Reason string    `json:"reason"`
+		Detail string    `json:"detail"`
+		At     time.Time `json:"at"`
+	}
+)
+
+func init() {
+	if n, err := strconv.Atoi(envOr("MAX_BODY_BYTES", "")); err == nil && n > 0 {
+		maxBodyBytes = n
+	}
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rowError) Cause() error {
+	return e.err
+}
+
+func (e *rowError) Unwrap() error {
+	return e.err
+}
+
+func newRowError(url string, err error) error {
+	return &rowError{Url: url, Reason: reasonReadError, err: err}
+}
+
+// validateRecord returns the quarantine reason and detail for a record that must not be processed
+func validateRecord(ed *edition, rec sourceRecord) (reason, detail string, ok bool) {
+	fields := []struct{ name, v string }{
+		{"title", rec.Title}, {"url", rec.Url}, {"abstract", rec.Abstract}, {"body_text", rec.BodyText}, {"body_html", rec.BodyHtml},
+	}
+	for _, f := range fields {
+		if !utf8.ValidString(f.v) {
+			return reasonBadEncoding, f.name + " is not valid utf-8", false
+		}
+	}
+	if !strings.HasPrefix(rec.Url, ed.BaseUrl) || ed.pageLoc(rec.Url) == "" {
+		return reasonBadUrl, fmt.Sprintf("url does not name a page below %s", ed.BaseUrl), false
+	}
+	if strings.TrimSpace(rec.BodyText) == "" && strings.TrimSpace(rec.BodyHtml) == "" {
+		return reasonEmptyBody, "body_text and body_html are empty", false
+	}
+	if n := len(rec.BodyText) + len(rec.BodyHtml); n > maxBodyBytes {
+		return reasonOversizedBody, fmt.Sprintf("body is %d bytes, limit %d", n, maxBodyBytes), false
+	}
+	return "", "", true
+}
+
+func openQuarantine(path string) *quarantine {
+	f, err := os.Create(path)
+	if err != nil {
+		err = errors.Wrap(err, "creating quarantine file")
+		log.Fatal(err)
+	}
+	return &quarantine{f: f, enc: json.NewEncoder(f), counts: make(map[string]int)}
+}
+
+func (q *quarantine) add(rec sourceRecord, reason, detail string) {
+	q.counts[reason]++
+	entry := quarantineEntry{Url: rec.Url, Title: rec.Title, Reason: reason, Detail: detail, At: time.Now()}
+	if err := q.enc.Encode(&entry); err != nil {
+		err = errors.Wrap(err, "writing quarantine entry")
+		log.Println(err)
+	}
+}
+
+// summarize logs how many records were skipped for each reason and closes the file
+func (q *quarantine) summarize() {
+	total := 0
+	reasons := make([]string, 0, len(q.counts))
+	for reason, n := range q.counts {
+		reasons = append(reasons, reason)
+		total += n
+	}
+	sort.Strings(reasons)
+	if total == 0 {
+		log.Println("quarantine: no records skipped")
+	} else {
+		parts := make([]string, len(reasons))
+		for i, reason := range reasons {
+			parts[i] = fmt.Sprintf("%s=%d", reason, q.counts[reason])
+		}
+		log.Printf("quarantine: skipped %d records (%s), see %s", total, strings.Join(parts, ", "), q.f.Name())
+	}
+	if err := q.f.Close(); err != nil {
+		err = errors.Wrap(err, "closing quarantine file")
+		log.Println(err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestValidateRecord(t *testing.T) {
+	ed := &edition{Lang: "en", BaseUrl: "https://en.wikibooks.org/wiki/"}
+	defer func(n int) { maxBodyBytes = n }(maxBodyBytes)
+	maxBodyBytes = 16
+
+	page := ed.BaseUrl + "Cookbook"
+	tests := []struct {
+		rec            sourceRecord
+		reason, detail string
+	}{
+		{sourceRecord{Url: page, BodyText: "bread"}, "", ""},
+		{sourceRecord{Url: page, BodyHtml: "<p>bread</p>"}, "", ""},
+		// the first invalid field in record order is reported
+		{sourceRecord{Url: page, Abstract: "\xff", BodyText: "\xfe", BodyHtml: "\xfd"}, reasonBadEncoding, "abstract is not valid utf-8"},
+		{sourceRecord{Url: page, BodyText: "ok", BodyHtml: "\xff"}, reasonBadEncoding, "body_html is not valid utf-8"},
+		{sourceRecord{Url: "https://de.wikibooks.org/wiki/Kochbuch", BodyText: "brot"}, reasonBadUrl, "url does not name a page below https://en.wikibooks.org/wiki/"},
+		{sourceRecord{Url: ed.BaseUrl, BodyText: "bread"}, reasonBadUrl, "url does not name a page below https://en.wikibooks.org/wiki/"},
+		{sourceRecord{Url: page, BodyText: " \n", BodyHtml: "\t"}, reasonEmptyBody, "body_text and body_html are empty"},
+		{sourceRecord{Url: page, BodyText: "0123456789", BodyHtml: "0123456789"}, reasonOversizedBody, "body is 20 bytes, limit 16"},
+	}
+	for _, tt := range tests {
+		reason, detail, ok := validateRecord(ed, tt.rec)
+		if reason != tt.reason || detail != tt.detail || ok != (tt.reason == "") {
+			t.Errorf("validateRecord(%+v) = %q, %q, %v, want %q, %q", tt.rec, reason, detail, ok, tt.reason, tt.detail)
+		}
+	}
+}
+
+func TestQuarantineFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quarantine.jsonl")
+	q := openQuarantine(path)
+	err := newRowError("https://en.wikibooks.org/wiki/Broken", errors.New("scanning row"))
+	var re *rowError
+	if !errors.As(err, &re) {
+		t.Fatal("a row error is not recognized as one")
+	}
+	q.add(sourceRecord{Url: re.Url}, reasonReadError, re.Error())
+	q.add(sourceRecord{Url: "https://en.wikibooks.org/wiki/Empty", Title: "Empty"}, reasonEmptyBody, "body_text and body_html are empty")
+	q.summarize()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e quarantineEntry
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Join([]string{e.Url, e.Title, e.Reason, e.Detail}, " "))
+	}
+	want := []string{
+		"https://en.wikibooks.org/wiki/Broken  read_error scanning row",
+		"https://en.wikibooks.org/wiki/Empty Empty empty_body body_text and body_html are empty",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("quarantine file holds\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Source yields wikibook records to the pipeline in the order their ids are assigned
+	Source interface {
+		// Next returns the next record, or io.EOF once the source is exhausted
+		Next() (sourceRecord, error)
+		Close() error
+	}
+	// sourceRecord holds the raw fields a Source extracts for a single wikibook page
+	sourceRecord struct {
+		Title    string
+		Url      string
+		Abstract string
+		BodyText string
+		BodyHtml string
+		Modified string // last-modified marker, empty when the source has none
+		LinkBase string // url relative links in BodyHtml resolve against, when it is not Url
+	}
+	// sliceSource serves records from memory: none for editions without pages of their own, or
+	// fixtures in tests
+	sliceSource struct {
+		records []sourceRecord
+		i       int
+	}
+)
+
+// openSource builds the Source selected by ETL_SOURCE for an edition, defaulting to the sqlite export.
+// ETL_SOURCE_<LANG>=none configures an edition without pages of its own, which only tokenizes pages
+// that language detection routes to it.
+func openSource(ed *edition) (Source, error) {
+	switch ed.Source {
+	case "sqlite":
+		return newSqliteSource(ed.db, ed.sqlite)
+	case "xml":
+		return newXmlDumpSource(editionEnv("XML_DUMP_PATH", ed.Lang, workDir+ed.Lang+"wikibooks-latest-pages-articles.xml"), ed.BaseUrl)
+	case "dir":
+		return newDirSource(editionEnv("DOCS_DIR", ed.Lang, workDir+"docs"), ed.BaseUrl)
+	case "none":
+		return newSliceSource(nil), nil
+	default:
+		return nil, errors.Errorf("unknown ETL_SOURCE %q", ed.Source)
+	}
+}
+
+func newSliceSource(records []sourceRecord) *sliceSource {
+	return &sliceSource{records: records}
+}
+
+func (s *sliceSource) Next() (sourceRecord, error) {
+	if s.i >= len(s.records) {
+		return sourceRecord{}, io.EOF
+	}
+	rec := s.records[s.i]
+	s.i++
+	return rec, nil
+}
+
+func (s *sliceSource) Close() error {
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSliceSource(t *testing.T) {
+	recs := []sourceRecord{
+		{Title: "Cookbook", Url: "https://en.wikibooks.org/wiki/Cookbook"},
+		{Title: "Bread", Url: "https://en.wikibooks.org/wiki/Cookbook/Bread", Modified: "2021-01-01"},
+	}
+	src := newSliceSource(recs)
+	if got := readAll(t, src); !reflect.DeepEqual(got, recs) {
+		t.Errorf("got %+v, want %+v", got, recs)
+	}
+	if _, err := src.Next(); err != io.EOF {
+		t.Errorf("an exhausted source returned %v, want io.EOF", err)
+	}
+	if got := readAll(t, newSliceSource(nil)); len(got) != 0 {
+		t.Errorf("an empty source returned %+v", got)
+	}
+}
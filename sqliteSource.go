@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// sqliteFields are the record fields a sqlite column can be mapped onto, in select order
+var sqliteFields = []string{"title", "url", "abstract", "body_text", "body_html", "modified"}
+
+type (
+	// sqliteSource reads wikibook rows from a sqlite export such as en_wikibooks.sqlite.
+	// The table is split into rowid ranges read concurrently by one worker each; every worker
+	// reads its range in (url, rowid) order, urls compared bytewise like Go strings whatever the
+	// column's collation, and Next merges the workers' streams, so page ids
+	// and parent links come out exactly as they would from a single ordered query.
+	sqliteSource struct {
+		db     *sql.DB
+		shards []*sqliteShard
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	}
+	sqliteShard struct {
+		rows chan sqliteRow
+		head *sqliteRow
+		done bool
+	}
+	sqliteRow struct {
+		rec   sourceRecord
+		rowid int64
+		err   error
+	}
+	// sqliteConfig locates the export and maps record fields onto table columns.
+	// A field mapped to "" is not read; url and title are required, modified is optional.
+	sqliteConfig struct {
+		Path    string
+		Table   string
+		Columns map[string]string
+		Shards  int // number of concurrent rowid range readers
+	}
+)
+
+// sqliteConfigFromEnv reads SQLITE_PATH, SQLITE_TABLE and SQLITE_COLUMNS for an edition, e.g.
+// SQLITE_COLUMNS="body_text=text,modified=last_modified" overrides only the listed fields.
+// The export defaults to ./<lang>_wikibooks.sqlite with a table named after the language, read by
+// SQLITE_SHARDS concurrent readers, one per CPU by default.
+func sqliteConfigFromEnv(lang string) (sqliteConfig, error) {
+	cfg := sqliteConfig{
+		Path:  editionEnv("SQLITE_PATH", lang, workDir+lang+"_wikibooks.sqlite"),
+		Table: editionEnv("SQLITE_TABLE", lang, lang),
+		Columns: map[string]string{
+			"title":     "title",
+			"url":       "url",
+			"abstract":  "abstract",
+			"body_text": "body_text",
+			"body_html": "body_html",
+			"modified":  "",
+		},
+	}
+	if n, err := strconv.Atoi(editionEnv("SQLITE_SHARDS", lang, "")); err == nil && n > 0 {
+		cfg.Shards = n
+	} else {
+		cfg.Shards = runtime.NumCPU()
+	}
+	overrides, err := parseMapping(editionEnv("SQLITE_COLUMNS", lang, ""))
+	if err != nil {
+		return cfg, errors.Wrap(err, "parsing SQLITE_COLUMNS")
+	}
+	for field, col := range overrides {
+		if _, ok := cfg.Columns[field]; !ok {
+			return cfg, errors.Errorf("SQLITE_COLUMNS: unknown field %q, expected one of %s", field, strings.Join(sqliteFields, ", "))
+		}
+		cfg.Columns[field] = col
+	}
+	return cfg, nil
+}
+
+// validate checks that the table and every mapped column exist in the export
+func (cfg sqliteConfig) validate(db *sql.DB) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(cfg.Table)))
+	if err != nil {
+		return errors.Wrapf(err, "reading columns of table %s", cfg.Table)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err = rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return errors.Wrap(err, "scanning table_info")
+		}
+		existing[name] = true
+	}
+	if err = rows.Err(); err != nil {
+		return errors.Wrap(err, "iterating table_info")
+	}
+	if len(existing) == 0 {
+		return errors.Errorf("table %q does not exist in %s", cfg.Table, cfg.Path)
+	}
+
+	for _, field := range []string{"title", "url"} {
+		if cfg.Columns[field] == "" {
+			return errors.Errorf("field %s must be mapped to a column", field)
+		}
+	}
+	var missing []string
+	for _, field := range sqliteFields {
+		if col := cfg.Columns[field]; col != "" && !existing[col] {
+			missing = append(missing, fmt.Sprintf("%s (for %s)", col, field))
+		}
+	}
+	if len(missing) > 0 {
+		var available []string
+		for name := range existing {
+			available = append(available, name)
+		}
+		sort.Strings(available)
+		return errors.Errorf("table %s is missing mapped columns %s; available columns: %s",
+			cfg.Table, strings.Join(missing, ", "), strings.Join(available, ", "))
+	}
+	return nil
+}
+
+// selectList builds the column list in sqliteFields order, selecting NULL for unmapped fields
+func (cfg sqliteConfig) selectList() string {
+	cols := make([]string, len(sqliteFields))
+	for i, field := range sqliteFields {
+		if col := cfg.Columns[field]; col != "" {
+			cols[i] = quoteIdent(col)
+		} else {
+			cols[i] = "NULL"
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// openSqlite opens an existing export, never creating an empty one at a wrong SQLITE_PATH, and
+// validates the column mapping against it so a bad configuration fails at startup
+func openSqlite(cfg sqliteConfig) (*sql.DB, error) {
+	db, err := sql.Open(dbDriver, "file:"+cfg.Path+"?mode=rw")
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening sqlite export %s", cfg.Path)
+	}
+	if err = cfg.validate(db); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "validating sqlite column mapping")
+	}
+	return db, nil
+}
+
+// newSqliteSource starts reading the table of an export opened by openSqlite
+func newSqliteSource(db *sql.DB, cfg sqliteConfig) (*sqliteSource, error) {
+	var lo, hi sql.NullInt64
+	err := db.QueryRow(fmt.Sprintf("SELECT MIN(rowid), MAX(rowid) FROM %s", quoteIdent(cfg.Table))).Scan(&lo, &hi)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting rowid range")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &sqliteSource{db: db, cancel: cancel}
+	if !lo.Valid {
+		return s, nil // empty table
+	}
+	query := fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid BETWEEN ? AND ? ORDER BY %s COLLATE BINARY, rowid",
+		cfg.selectList(), quoteIdent(cfg.Table), quoteIdent(cfg.Columns["url"]))
+	span := (hi.Int64-lo.Int64)/int64(cfg.Shards) + 1
+	for from := lo.Int64; from <= hi.Int64; from += span {
+		shard := &sqliteShard{rows: make(chan sqliteRow, 64)}
+		s.shards = append(s.shards, shard)
+		s.wg.Add(1)
+		go s.read(ctx, shard, query, from, from+span-1)
+	}
+	return s, nil
+}
+
+// read streams one rowid range into its shard's channel
+func (s *sqliteSource) read(ctx context.Context, shard *sqliteShard, query string, from, to int64) {
+	defer s.wg.Done()
+	defer close(shard.rows)
+
+	send := func(r sqliteRow) bool {
+		select {
+		case shard.rows <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		send(sqliteRow{err: errors.Wrapf(err, "getting rows %d-%d", from, to)})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			r                                                  sqliteRow
+			title, url, abstract, bodyText, bodyHtml, modified sql.NullString
+		)
+		if err = rows.Scan(&r.rowid, &title, &url, &abstract, &bodyText, &bodyHtml, &modified); err != nil {
+			r.err = newRowError(url.String, errors.Wrap(err, "scanning row"))
+		}
+		r.rec = sourceRecord{
+			Title:    title.String,
+			Url:      url.String,
+			Abstract: abstract.String,
+			BodyText: bodyText.String,
+			BodyHtml: bodyHtml.String,
+			Modified: modified.String,
+		}
+		if !send(r) {
+			return
+		}
+	}
+	if err = rows.Err(); err != nil {
+		send(sqliteRow{err: errors.Wrapf(err, "iterating rows %d-%d", from, to)})
+	}
+}
+
+// Next returns the lowest (url, rowid) row among the shard heads. Errors are returned as soon as
+// a shard produces them; row errors are quarantined and never take a page id, so this keeps ids stable.
+func (s *sqliteSource) Next() (sourceRecord, error) {
+	var next *sqliteShard
+	for _, shard := range s.shards {
+		if shard.head == nil && !shard.done {
+			r, ok := <-shard.rows
+			if !ok {
+				shard.done = true
+				continue
+			}
+			shard.head = &r
+		}
+		if shard.head == nil {
+			continue
+		}
+		if shard.head.err != nil {
+			r := shard.head
+			shard.head = nil
+			return r.rec, r.err
+		}
+		if next == nil || shard.head.rec.Url < next.head.rec.Url ||
+			(shard.head.rec.Url == next.head.rec.Url && shard.head.rowid < next.head.rowid) {
+			next = shard
+		}
+	}
+	if next == nil {
+		return sourceRecord{}, io.EOF
+	}
+	r := next.head
+	next.head = nil
+	return r.rec, nil
+}
+
+func (s *sqliteSource) Close() error {
+	s.cancel()
+	for _, shard := range s.shards {
+		for range shard.rows {
+		}
+	}
+	s.wg.Wait()
+	return s.db.Close()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// readAll drains src into a slice, failing the test on errors
+func readAll(t *testing.T, src Source) []sourceRecord {
+	t.Helper()
+	var recs []sourceRecord
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	if err := src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func TestSqliteShardMergeOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "en_wikibooks.sqlite")
+	db, err := sql.Open(dbDriver, "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(`CREATE TABLE en (title TEXT, url TEXT, abstract TEXT, body_text TEXT, body_html TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	// urls in random rowid order, some of them repeated so the rowid tie-break matters
+	rnd := rand.New(rand.NewSource(1))
+	for _, i := range rnd.Perm(500) {
+		u := fmt.Sprintf("https://en.wikibooks.org/wiki/Book_%d/Page_%d", i%7, i%70)
+		if _, err = db.Exec(`INSERT INTO en VALUES (?, ?, '', ?, '')`, fmt.Sprint("page ", i), u, fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	// the source closes its database, so every read opens its own
+	read := func(shards int) []sourceRecord {
+		db, err := sql.Open(dbDriver, "file:"+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := sqliteConfigFromEnv("en")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Shards = shards
+		src, err := newSqliteSource(db, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readAll(t, src)
+	}
+	want := read(1)
+	if len(want) != 500 {
+		t.Fatalf("sequential read gave %d rows, want 500", len(want))
+	}
+	for _, shards := range []int{2, 3, 7, 64} {
+		if got := read(shards); !reflect.DeepEqual(got, want) {
+			t.Errorf("%d shards read the rows in another order than a sequential read", shards)
+		}
+	}
+}
+
+func TestSqliteConfigErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "en_wikibooks.sqlite")
+	db, err := sql.Open(dbDriver, "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(`CREATE TABLE en (title TEXT, url TEXT, abstract TEXT, body_text TEXT, body_html TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	t.Setenv("SQLITE_COLUMNS", "body=text")
+	if _, err = sqliteConfigFromEnv("en"); err == nil || !strings.Contains(err.Error(), `unknown field "body"`) {
+		t.Errorf("unknown field: got %v", err)
+	}
+	t.Setenv("SQLITE_COLUMNS", "")
+
+	tests := []struct {
+		name string
+		cfg  func(*sqliteConfig)
+		want string
+	}{
+		{"missing file", func(c *sqliteConfig) { c.Path = filepath.Join(filepath.Dir(path), "de_wikibooks.sqlite") }, "opening sqlite export"},
+		{"missing table", func(c *sqliteConfig) { c.Table = "de" }, `table "de" does not exist`},
+		{"missing column", func(c *sqliteConfig) { c.Columns["body_text"] = "text" }, "missing mapped columns text (for body_text)"},
+		{"unmapped url", func(c *sqliteConfig) { c.Columns["url"] = "" }, "field url must be mapped"},
+		{"valid", func(c *sqliteConfig) {}, ""},
+	}
+	for _, tt := range tests {
+		cfg, err := sqliteConfigFromEnv("en")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Path = path
+		tt.cfg(&cfg)
+		db, err := openSqlite(cfg)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else {
+				db.Close()
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(filepath.Dir(path), "de_wikibooks.sqlite")); !os.IsNotExist(err) {
+		t.Error("opening a missing export created an empty file")
+	}
+}
+
+func TestSqliteShardsIgnoreColumnCollation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "en_wikibooks.sqlite")
+	db, err := sql.Open(dbDriver, "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(`CREATE TABLE en (title TEXT, url TEXT COLLATE NOCASE, abstract TEXT, body_text TEXT, body_html TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for i, loc := range []string{"b", "A", "a", "B", "Book/c", "book/C", "_x", "Z"} {
+		if _, err = db.Exec(`INSERT INTO en VALUES (?, ?, '', '', '')`, fmt.Sprint(i), "https://en.wikibooks.org/wiki/"+loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	for _, shards := range []int{1, 3} {
+		cfg, err := sqliteConfigFromEnv("en")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Path, cfg.Shards = path, shards
+		db, err := openSqlite(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := newSqliteSource(db, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs := readAll(t, src)
+		for i := 1; i < len(recs); i++ {
+			if recs[i].Url < recs[i-1].Url {
+				t.Errorf("%d shards: %s came after %s", shards, recs[i].Url, recs[i-1].Url)
+			}
+		}
+	}
+}
+
+func TestSqliteShardsDefaultToCPUs(t *testing.T) {
+	cfg, err := sqliteConfigFromEnv("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Shards != runtime.NumCPU() {
+		t.Errorf("default shards %d, want one per CPU, %d", cfg.Shards, runtime.NumCPU())
+	}
+	t.Setenv("SQLITE_SHARDS_EN", "2")
+	if cfg, _ = sqliteConfigFromEnv("en"); cfg.Shards != 2 {
+		t.Errorf("SQLITE_SHARDS_EN=2 gave %d shards", cfg.Shards)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type (
+	// pageStage holds the per page data that is only needed once the whole corpus has been read,
+	// so the page writer can drop it from memory along with the page body. It is stored in the
+	// etl_staging collection and dropped once the run has used it.
+	pageStage struct {
+		Id       int             `bson:"_id"`
+		Postings []stagedPosting `bson:"postings,omitempty"` // token and candidate phrase postings
+		Entities []entityQty     `bson:"entities,omitempty"` // entity candidates, before admitEntities
+	}
+	stagedPosting struct {
+		Key       string `bson:"key"` // token key, see tokenKey
+		Positions []byte `bson:"positions"`
+		Offsets   []byte `bson:"offsets,omitempty"`
+	}
+)
+
+// newPageStage collects what wb keeps for the post-read passes; ok is false when there is nothing to keep
+func newPageStage(wb *wikibook) (st pageStage, ok bool) {
+	st.Id = wb.Id
+	for _, m := range []map[string]posting{wb.postings, wb.phrasePostings} {
+		for key, p := range m {
+			st.Postings = append(st.Postings, stagedPosting{Key: key, Positions: p.positions, Offsets: p.offsets})
+		}
+	}
+	for key, q := range wb.entityQty {
+		_, text := splitTokenKey(key)
+		st.Entities = append(st.Entities, entityQty{Text: text, Qty: q})
+	}
+	return st, len(st.Postings) > 0 || len(st.Entities) > 0
+}
+
+// stagePages writes the staged data of wbs
+func stagePages(ctx context.Context, wbs []*wikibook) {
+	docs := make([]interface{}, 0, len(wbs))
+	for _, wb := range wbs {
+		if st, ok := newPageStage(wb); ok {
+			docs = append(docs, &st)
+		}
+	}
+	if len(docs) == 0 {
+		return
+	}
+	if _, err := stageColl.InsertMany(ctx, docs); err != nil {
+		err = errors.Wrap(err, "inserting staged pages")
+		log.Println(err)
+	}
+}
+
+// eachStaged calls fn with every staged page, reading them one at a time
+func eachStaged(ctx context.Context, fn func(st *pageStage)) {
+	cur, err := stageColl.Find(ctx, bson.M{})
+	if err != nil {
+		err = errors.Wrap(err, "reading staged pages")
+		log.Fatal(err)
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var st pageStage
+		if err = cur.Decode(&st); err != nil {
+			err = errors.Wrap(err, "decoding staged page")
+			log.Fatal(err)
+		}
+		fn(&st)
+	}
+	if err = cur.Err(); err != nil {
+		err = errors.Wrap(err, "reading staged pages")
+		log.Fatal(err)
+	}
+}
+
+// dropStaging clears the staged pages, left over from an interrupted run or used up by this one
+func dropStaging(ctx context.Context) {
+	if err := stageColl.Drop(ctx); err != nil {
+		err = errors.Wrap(err, "dropping staged pages")
+		log.Println(err)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// conflating is implemented by normalizers that map many surface forms onto one token, such as
+	// stemmers. The word entering the first conflating stage is kept as the token's surface form.
+	conflating interface {
+		Normalizer
+		conflates()
+	}
+	// porter2Stemmer reduces English words to their Porter2 (Snowball English) stems
+	porter2Stemmer struct{}
+)
+
+var (
+	// surfaceForms counts, per token key, the words that a conflating stage turned into that token
+	surfaceForms = make(map[string]map[string]int)
+
+	porter2Exceptions = map[string]string{
+		"skis": "ski", "skies": "sky", "dying": "die", "lying": "lie", "tying": "tie",
+		"idly": "idl", "gently": "gentl", "ugly": "ugli", "early": "earli", "only": "onli", "singly": "singl",
+		"sky": "sky", "news": "news", "howe": "howe", "atlas": "atlas", "cosmos": "cosmos", "bias": "bias", "andes": "andes",
+	}
+	porter2Exceptions1a = map[string]bool{
+		"inning": true, "outing": true, "canning": true, "herring": true,
+		"earring": true, "proceed": true, "exceed": true, "succeed": true,
+	}
+	porter2Step2 = []porter2Rule{
+		{"ization", "ize"}, {"ational", "ate"}, {"fulness", "ful"}, {"ousness", "ous"}, {"iveness", "ive"},
+		{"tional", "tion"}, {"biliti", "ble"}, {"lessli", "less"}, {"entli", "ent"}, {"ation", "ate"},
+		{"alism", "al"}, {"aliti", "al"}, {"ousli", "ous"}, {"iviti", "ive"}, {"fulli", "ful"},
+		{"enci", "ence"}, {"anci", "ance"}, {"abli", "able"}, {"izer", "ize"}, {"ator", "ate"},
+		{"alli", "al"}, {"bli", "ble"}, {"ogi", "og"}, {"li", ""},
+	}
+	porter2Step3 = []porter2Rule{
+		{"ational", "ate"}, {"tional", "tion"}, {"alize", "al"}, {"icate", "ic"}, {"iciti", "ic"},
+		{"ative", ""}, {"ical", "ic"}, {"ness", ""}, {"ful", ""},
+	}
+	porter2Step4 = []string{
+		"ement", "ance", "ence", "able", "ible", "ment", "ant", "ent", "ism", "ate",
+		"iti", "ous", "ive", "ize", "ion", "al", "er", "ic",
+	}
+)
+
+type porter2Rule struct {
+	suffix, repl string
+}
+
+func init() {
+	tokenStages["stem"] = func(ed *edition) (interface{}, error) {
+		if ed.Lang != "en" {
+			return nil, errors.Errorf("the stem stage only supports English, not %s", ed.Lang)
+		}
+		return porter2Stemmer{}, nil
+	}
+}
+
+func (porter2Stemmer) conflates() {}
+
+func (porter2Stemmer) Normalize(tkn string) string {
+	return stemEnglish(tkn)
+}
+
+// recordSurfaceForm notes that surface became the token key
+func recordSurfaceForm(key, surface string) {
+	forms, ok := surfaceForms[key]
+	if !ok {
+		forms = make(map[string]int)
+		surfaceForms[key] = forms
+	}
+	forms[surface]++
+}
+
+// surfaceFormsOf returns the surface forms seen for a token key, most frequent first
+func surfaceFormsOf(key string) []string {
+	forms := surfaceForms[key]
+	if len(forms) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(forms))
+	for f := range forms {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if forms[out[i]] != forms[out[j]] {
+			return forms[out[i]] > forms[out[j]]
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+func porter2Vowel(c byte) bool {
+	switch c {
+	case 'a', 'e', 'i', 'o', 'u', 'y':
+		return true
+	}
+	return false
+}
+
+// stemEnglish implements the Porter2 English stemming algorithm for lowercase ascii words;
+// anything else is returned unchanged
+func stemEnglish(w string) string {
+	if len(w) <= 2 {
+		return w
+	}
+	for i := 0; i < len(w); i++ {
+		if (w[i] < 'a' || w[i] > 'z') && w[i] != '\'' {
+			return w
+		}
+	}
+	if s, ok := porter2Exceptions[w]; ok {
+		return s
+	}
+
+	b := []byte(strings.TrimPrefix(w, "'"))
+	for i := range b {
+		if b[i] == 'y' && (i == 0 || porter2Vowel(b[i-1])) {
+			b[i] = 'Y'
+		}
+	}
+	r1, r2 := porter2Regions(b)
+
+	b = porter2Step0(b)
+	b = porter2Step1a(b)
+	if porter2Exceptions1a[string(b)] {
+		return strings.ToLower(string(b))
+	}
+	b = porter2Step1b(b, r1)
+	b = porter2Step1c(b)
+	b = porter2Apply(b, porter2Step2, r1, r2, 2)
+	b = porter2Apply(b, porter2Step3, r1, r2, 3)
+	b = porter2Step4Apply(b, r2)
+	b = porter2Step5(b, r1, r2)
+	return strings.ToLower(string(b))
+}
+
+// porter2Regions finds R1 and R2, the regions after the first and second non-vowel following a vowel
+func porter2Regions(b []byte) (r1, r2 int) {
+	r1 = len(b)
+	found := false
+	for _, p := range []string{"gener", "commun", "arsen"} {
+		if strings.HasPrefix(string(b), p) {
+			r1, found = len(p), true
+			break
+		}
+	}
+	if !found {
+		for i := 1; i < len(b); i++ {
+			if !porter2Vowel(b[i]) && porter2Vowel(b[i-1]) {
+				r1 = i + 1
+				break
+			}
+		}
+	}
+	r2 = len(b)
+	for i := r1 + 1; i < len(b); i++ {
+		if !porter2Vowel(b[i]) && porter2Vowel(b[i-1]) {
+			r2 = i + 1
+			break
+		}
+	}
+	return r1, r2
+}
+
+func porter2HasSuffix(b []byte, s string) bool {
+	return strings.HasSuffix(string(b), s)
+}
+
+// porter2ShortSyllable reports whether b ends in a short syllable
+func porter2ShortSyllable(b []byte) bool {
+	n := len(b)
+	if n == 2 {
+		return porter2Vowel(b[0]) && !porter2Vowel(b[1])
+	}
+	if n >= 3 {
+		c := b[n-1]
+		return !porter2Vowel(b[n-3]) && porter2Vowel(b[n-2]) && !porter2Vowel(c) && c != 'w' && c != 'x' && c != 'Y'
+	}
+	return false
+}
+
+func porter2Step0(b []byte) []byte {
+	for _, s := range []string{"'s'", "'s", "'"} {
+		if porter2HasSuffix(b, s) {
+			return b[:len(b)-len(s)]
+		}
+	}
+	return b
+}
+
+func porter2Step1a(b []byte) []byte {
+	switch {
+	case porter2HasSuffix(b, "sses"):
+		return b[:len(b)-2]
+	case porter2HasSuffix(b, "ied"), porter2HasSuffix(b, "ies"):
+		if len(b) > 4 {
+			return b[:len(b)-2]
+		}
+		return b[:len(b)-1]
+	case porter2HasSuffix(b, "us"), porter2HasSuffix(b, "ss"):
+		return b
+	case porter2HasSuffix(b, "s"):
+		for _, c := range b[:len(b)-2] {
+			if porter2Vowel(c) {
+				return b[:len(b)-1]
+			}
+		}
+	}
+	return b
+}
+
+func porter2Step1b(b []byte, r1 int) []byte {
+	for _, s := range []string{"eedly", "ingly", "edly", "eed", "ing", "ed"} {
+		if !porter2HasSuffix(b, s) {
+			continue
+		}
+		stem := b[:len(b)-len(s)]
+		if s == "eed" || s == "eedly" {
+			if len(stem) >= r1 {
+				return append(stem, "ee"...)
+			}
+			return b
+		}
+		hasVowel := false
+		for _, c := range stem {
+			if porter2Vowel(c) {
+				hasVowel = true
+				break
+			}
+		}
+		if !hasVowel {
+			return b
+		}
+		b = stem
+		switch {
+		case porter2HasSuffix(b, "at"), porter2HasSuffix(b, "bl"), porter2HasSuffix(b, "iz"):
+			return append(b, 'e')
+		case len(b) >= 2 && b[len(b)-1] == b[len(b)-2] && strings.IndexByte("bdfgmnprt", b[len(b)-1]) >= 0:
+			return b[:len(b)-1]
+		case porter2ShortSyllable(b) && r1 >= len(b):
+			return append(b, 'e')
+		}
+		return b
+	}
+	return b
+}
+
+func porter2Step1c(b []byte) []byte {
+	n := len(b)
+	if n > 2 && (b[n-1] == 'y' || b[n-1] == 'Y') && !porter2Vowel(b[n-2]) {
+		b[n-1] = 'i'
+	}
+	return b
+}
+
+// porter2Apply replaces the longest matching suffix of rules when it lies in R1, with the
+// step specific conditions for ogi, li (step 2) and ative (step 3)
+func porter2Apply(b []byte, rules []porter2Rule, r1, r2, step int) []byte {
+	var match *porter2Rule
+	for i := range rules {
+		if porter2HasSuffix(b, rules[i].suffix) && (match == nil || len(rules[i].suffix) > len(match.suffix)) {
+			match = &rules[i]
+		}
+	}
+	if match == nil {
+		return b
+	}
+	start := len(b) - len(match.suffix)
+	if start < r1 {
+		return b
+	}
+	switch {
+	case step == 2 && match.suffix == "ogi":
+		if start == 0 || b[start-1] != 'l' {
+			return b
+		}
+	case step == 2 && match.suffix == "li":
+		if start == 0 || strings.IndexByte("cdeghkmnrt", b[start-1]) < 0 {
+			return b
+		}
+	case step == 3 && match.suffix == "ative":
+		if start < r2 {
+			return b
+		}
+	}
+	return append(b[:start], match.repl...)
+}
+
+func porter2Step4Apply(b []byte, r2 int) []byte {
+	match := ""
+	for _, s := range porter2Step4 {
+		if porter2HasSuffix(b, s) && len(s) > len(match) {
+			match = s
+		}
+	}
+	if match == "" {
+		return b
+	}
+	start := len(b) - len(match)
+	if start < r2 {
+		return b
+	}
+	if match == "ion" && (start == 0 || (b[start-1] != 's' && b[start-1] != 't')) {
+		return b
+	}
+	return b[:start]
+}
+
+func porter2Step5(b []byte, r1, r2 int) []byte {
+	n := len(b)
+	switch {
+	case n > 0 && b[n-1] == 'e':
+		if n-1 >= r2 || (n-1 >= r1 && !porter2ShortSyllable(b[:n-1])) {
+			return b[:n-1]
+		}
+	case n > 1 && b[n-1] == 'l':
+		if n-1 >= r2 && b[n-2] == 'l' {
+			return b[:n-1]
+		}
+	}
+	return b
+}
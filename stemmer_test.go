@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+// porter2Vocabulary pairs words with their stems from the Snowball English sample vocabulary
+// (voc.txt and output.txt) plus the words the algorithm's special cases exist for
+var porter2Vocabulary = map[string]string{
+	"consign": "consign", "consigned": "consign", "consigning": "consign", "consignment": "consign",
+	"consist": "consist", "consisted": "consist", "consistency": "consist", "consistent": "consist",
+	"consistently": "consist", "consisting": "consist", "consists": "consist",
+	"consolation": "consol", "consolations": "consol", "consolatory": "consolatori", "console": "consol",
+	"consoled": "consol", "consoles": "consol", "consolidate": "consolid", "consolidated": "consolid",
+	"consolidating": "consolid", "consoling": "consol", "consolingly": "consol", "consols": "consol",
+	"consonant": "conson", "consort": "consort", "consorted": "consort", "consorting": "consort",
+	"conspicuous": "conspicu", "conspicuously": "conspicu", "conspiracy": "conspiraci",
+	"conspirator": "conspir", "conspirators": "conspir", "conspire": "conspir", "conspired": "conspir",
+	"conspiring": "conspir", "constable": "constabl", "constables": "constabl", "constance": "constanc",
+	"constancy": "constanc", "constant": "constant",
+	"knack": "knack", "knackeries": "knackeri", "knacks": "knack", "knag": "knag", "knave": "knave",
+	"knaves": "knave", "knavish": "knavish", "kneaded": "knead", "kneading": "knead", "knee": "knee",
+	"kneel": "kneel", "kneeled": "kneel", "kneeling": "kneel", "kneels": "kneel", "knees": "knee",
+	"knell": "knell", "knelt": "knelt", "knew": "knew", "knick": "knick", "knif": "knif", "knife": "knife",
+	"knight": "knight", "knightly": "knight", "knights": "knight", "knit": "knit", "knits": "knit",
+	"knitted": "knit", "knitting": "knit", "knives": "knive", "knob": "knob", "knobs": "knob",
+	"knock": "knock", "knocked": "knock", "knocker": "knocker", "knockers": "knocker", "knocking": "knock",
+	"knocks": "knock", "knopp": "knopp", "knot": "knot", "knots": "knot",
+	// special cases
+	"skies": "sky", "dying": "die", "lying": "lie", "tying": "tie", "news": "news", "innings": "inning",
+	"proceed": "proceed", "exceed": "exceed", "succeed": "succeed", "gently": "gentl", "early": "earli",
+	"only": "onli", "singly": "singl", "generously": "generous", "generate": "generat", "general": "general",
+	"communism": "communism", "arsenal": "arsenal",
+	// steps 0 to 1c
+	"caresses": "caress", "ties": "tie", "cries": "cri", "gas": "gas", "this": "this", "kiwis": "kiwi",
+	"hopping": "hop", "hoped": "hope", "luxuriating": "luxuri", "agreed": "agre", "feed": "feed",
+	"cry": "cri", "by": "by", "say": "say", "boy's": "boy", "'tis": "tis",
+}
+
+func TestStemEnglish(t *testing.T) {
+	for w, want := range porter2Vocabulary {
+		if got := stemEnglish(w); got != want {
+			t.Errorf("stemEnglish(%q) = %q, want %q", w, got, want)
+		}
+	}
+}
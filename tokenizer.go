@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultTokenChain reproduces the original parseDoc behavior
+const defaultTokenChain = "ascii,lower,dictionary,stopwords"
+
+type (
+	// token is a word produced by a Tokenizer. Pos is its index in the split word sequence,
+	// counted before any filtering, so adjacent tokens have consecutive positions. Surface is
+	// the word as it entered a conflating stage such as stemming, empty if the chain has none.
+	// Offset is the byte offset of the word's first character in the split text.
+	token struct {
+		Text    string
+		Pos     int
+		Offset  int
+		Surface string
+	}
+	// Tokenizer turns page text into the tokens parseDoc counts
+	Tokenizer interface {
+		Tokenize(text string) []token
+	}
+	// Splitter breaks page text into raw words
+	Splitter interface {
+		Split(text string) []token
+	}
+	// Normalizer rewrites a word; returning "" drops it
+	Normalizer interface {
+		Normalize(tkn string) string
+	}
+	// Filter decides whether a word is kept
+	Filter interface {
+		Keep(tkn string) bool
+	}
+	// tokenChain is a Tokenizer built from a Splitter followed by Normalizer and Filter stages applied in order
+	tokenChain struct {
+		splitter Splitter
+		stages   []interface{}
+	}
+
+	asciiSplitter    struct{}
+	lowerNormalizer  struct{}
+	dictionaryFilter struct {
+		ed *edition
+	}
+	stopWordFilter struct {
+		ed *edition
+	}
+)
+
+// tokenStages are the stages a TOKENIZER chain can name; each builds a Splitter, Normalizer or Filter
+// for an edition, or fails if the stage does not support the edition
+var tokenStages = map[string]func(ed *edition) (interface{}, error){
+	"ascii":      func(*edition) (interface{}, error) { return asciiSplitter{}, nil },
+	"lower":      func(*edition) (interface{}, error) { return lowerNormalizer{}, nil },
+	"dictionary": func(ed *edition) (interface{}, error) { return dictionaryFilter{ed}, nil },
+	"stopwords":  func(ed *edition) (interface{}, error) { return stopWordFilter{ed}, nil },
+}
+
+// newTokenChain builds a tokenizer from a comma separated list of stage names, a splitter first
+func newTokenChain(ed *edition, spec string) (*tokenChain, error) {
+	c := &tokenChain{}
+	for i, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		build, ok := tokenStages[name]
+		if !ok {
+			names := make([]string, 0, len(tokenStages))
+			for n := range tokenStages {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, errors.Errorf("unknown tokenizer stage %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+		stage, err := build(ed)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			s, ok := stage.(Splitter)
+			if !ok {
+				return nil, errors.Errorf("tokenizer chain must start with a splitter, %q is not one", name)
+			}
+			c.splitter = s
+			continue
+		}
+		switch stage.(type) {
+		case Normalizer, Filter:
+			c.stages = append(c.stages, stage)
+		default:
+			return nil, errors.Errorf("tokenizer stage %q must be a normalizer or filter", name)
+		}
+	}
+	if c.splitter == nil {
+		return nil, errors.New("tokenizer chain is empty")
+	}
+	return c, nil
+}
+
+func (c *tokenChain) Tokenize(text string) []token {
+	tkns := c.splitter.Split(text)
+	out := tkns[:0]
+	for _, t := range tkns {
+		if t.Text, t.Surface = c.apply(t.Text); t.Text != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// apply runs a word through the stages, returning "" once it is dropped, along with the
+// surface form the word had when it entered the first conflating stage
+func (c *tokenChain) apply(tkn string) (string, string) {
+	surface := ""
+	for _, stage := range c.stages {
+		switch s := stage.(type) {
+		case Normalizer:
+			before := tkn
+			if tkn = s.Normalize(tkn); tkn == "" {
+				return "", ""
+			}
+			if _, ok := s.(conflating); ok && surface == "" {
+				surface = before
+			}
+		case Filter:
+			if !s.Keep(tkn) {
+				return "", ""
+			}
+		}
+	}
+	return tkn, surface
+}
+
+// Split drops every byte that is not ASCII alphanumeric or a space, then splits on spaces
+func (asciiSplitter) Split(text string) []token {
+	var (
+		tkns  []token
+		word  []byte
+		start int
+	)
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		switch {
+		case ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9'):
+			if len(word) == 0 {
+				start = i
+			}
+			word = append(word, b)
+		case b == ' ' && len(word) > 0:
+			tkns = append(tkns, token{Text: string(word), Pos: len(tkns), Offset: start})
+			word = word[:0]
+		}
+	}
+	if len(word) > 0 {
+		tkns = append(tkns, token{Text: string(word), Pos: len(tkns), Offset: start})
+	}
+	return tkns
+}
+
+func (lowerNormalizer) Normalize(tkn string) string {
+	return strings.ToLower(tkn)
+}
+
+// Keep only checks the dictionary in dictionary vocabulary mode; otherwise pruneVocabulary
+// decides on the words after reading
+func (f dictionaryFilter) Keep(tkn string) bool {
+	return f.ed.vocabulary != vocabDictionary || f.ed.dictionary[tkn]
+}
+
+func (f stopWordFilter) Keep(tkn string) bool {
+	return !f.ed.stopWords[tkn]
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// legacyTokens is the tokenization parseDoc did before the token chain: clean, ToLower, Fields,
+// then the dictionary and stopword checks
+func legacyTokens(ed *edition, text string) []string {
+	s := []byte(text)
+	j := 0
+	for _, b := range s {
+		if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') || b == ' ' {
+			s[j] = b
+			j++
+		}
+	}
+	var tkns []string
+	for _, v := range strings.Fields(strings.ToLower(string(s[:j]))) {
+		if ed.dictionary[v] && !ed.stopWords[v] {
+			tkns = append(tkns, v)
+		}
+	}
+	return tkns
+}
+
+func TestDefaultTokenChainMatchesLegacy(t *testing.T) {
+	ed := &edition{
+		Lang:       "en",
+		vocabulary: vocabDictionary,
+		dictionary: map[string]bool{"endnext": true, "ab": true, "bread": true, "flour": true, "the": true, "cafe": true, "x2": true},
+		stopWords:  map[string]bool{"the": true},
+	}
+	c, err := newTokenChain(ed, defaultTokenChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{
+		"end.Next",                  // punctuation glues words
+		"a\nb",                      // so do newlines and tabs
+		"The BREAD  and\tthe flour", // stopwords and unknown words drop out
+		"café x2 café",              // non-ASCII letters are dropped, not folded
+		"  bread  ",
+		"",
+	}
+	for _, text := range texts {
+		var got []string
+		for _, tkn := range c.Tokenize(text) {
+			got = append(got, tkn.Text)
+		}
+		if want := legacyTokens(ed, text); !reflect.DeepEqual(got, want) {
+			t.Errorf("Tokenize(%q) = %q, want the legacy %q", text, got, want)
+		}
+	}
+	var got []string
+	for _, tkn := range c.Tokenize("end.Next a\nb") {
+		got = append(got, tkn.Text)
+	}
+	if want := []string{"endnext", "ab"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewTokenChainErrors(t *testing.T) {
+	ed := &edition{Lang: "en"}
+	tests := []struct {
+		spec, want string
+	}{
+		{"lower,ascii", `tokenizer chain must start with a splitter, "lower" is not one`},
+		{"ascii,bogus", `unknown tokenizer stage "bogus"`},
+		{"ascii,ascii", `tokenizer stage "ascii" must be a normalizer or filter`},
+		{"", `unknown tokenizer stage ""`},
+	}
+	for _, tt := range tests {
+		if _, err := newTokenChain(ed, tt.spec); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("newTokenChain(%q) error %v, want one containing %q", tt.spec, err, tt.want)
+		}
+	}
+	if _, err := newTokenChain(ed, "ascii, lower"); err != nil {
+		t.Errorf("a valid chain failed: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// vocabulary modes, set per edition with VOCABULARY
+const (
+	vocabDictionary = "dictionary" // only dictionary words become tokens
+	vocabCorpus     = "corpus"     // words become tokens by their document frequency alone
+	vocabMerged     = "merged"     // dictionary words, plus other words with enough document frequency
+)
+
+var (
+	// vocabMinDf and vocabMaxDf bound the document frequency of corpus admitted words, the maximum
+	// as a share of the edition's pages so boilerplate on every page stays out
+	vocabMinDf = 3
+	vocabMaxDf = 1.0
+)
+
+func init() {
+	if n, err := strconv.Atoi(envOr("VOCAB_MIN_DF", "")); err == nil && n > 0 {
+		vocabMinDf = n
+	}
+	if f, err := strconv.ParseFloat(envOr("VOCAB_MAX_DF", ""), 64); err == nil && f > 0 && f <= 1 {
+		vocabMaxDf = f
+	}
+}
+
+func validVocabulary(mode string) error {
+	switch mode {
+	case vocabDictionary, vocabCorpus, vocabMerged:
+		return nil
+	}
+	return errors.Errorf("unknown VOCABULARY %q, want %s, %s or %s", mode, vocabDictionary, vocabCorpus, vocabMerged)
+}
+
+// pruneVocabulary drops the tokens of corpus and merged editions whose document frequency is out of
+// bounds, once every page has been read. Incremental runs only see the changed pages, so they
+// cannot judge document frequencies: they keep the tokens a previous run admitted and drop the
+// others until the next full run.
+// With VOCAB_REPORT set, the tokens admitted only by their document frequency are written there.
+func pruneVocabulary(wbs []*wikibook, incremental bool) {
+	pagesByLang := make(map[string]int)
+	for _, wb := range wbs {
+		pagesByLang[wb.tokenLang()]++
+	}
+	dropped := make(map[string]bool)
+	var corpusOnly []string
+	for key, refs := range tokenRefs {
+		lang, tkn := splitTokenKey(key)
+		ed := editionsByLang[lang]
+		if ed.vocabulary == vocabDictionary || (ed.vocabulary == vocabMerged && ed.dictionary[tkn]) {
+			continue
+		}
+		if incremental {
+			if _, known := tokenIds[key]; !known {
+				dropped[key] = true
+			}
+			continue
+		}
+		df := len(refs)
+		if df >= vocabMinDf && float64(df) <= vocabMaxDf*float64(pagesByLang[lang]) && hasLetter(tkn) {
+			if !ed.dictionary[tkn] {
+				corpusOnly = append(corpusOnly, key)
+			}
+			continue
+		}
+		dropped[key] = true
+	}
+	if len(dropped) == 0 && len(corpusOnly) == 0 {
+		return
+	}
+	log.Printf("vocabulary: dropped %d tokens, %d admitted only by document frequency", len(dropped), len(corpusOnly))
+
+	for key := range dropped {
+		delete(tokenRefs, key)
+		delete(surfaceForms, key)
+		allTokensMap.Delete(key)
+	}
+	for _, wb := range wbs {
+		changed := false
+		for key := range wb.tknQtyMap {
+			if dropped[key] {
+				delete(wb.tknQtyMap, key)
+				delete(wb.fieldQtys, key)
+				changed = true
+			}
+		}
+		if changed {
+			wb.recount()
+		}
+	}
+
+	if path := os.Getenv("VOCAB_REPORT"); path != "" {
+		writeVocabReport(path, corpusOnly)
+	}
+}
+
+// writeVocabReport lists corpus admitted tokens with their document frequency, most frequent first
+func writeVocabReport(path string, keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b := len(tokenRefs[keys[i]]), len(tokenRefs[keys[j]]); a != b {
+			return a > b
+		}
+		return keys[i] < keys[j]
+	})
+	var b strings.Builder
+	b.WriteString("tokens admitted only by document frequency (token, document frequency):\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s\t%d\n", k, len(tokenRefs[k]))
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		log.Println(errors.Wrap(err, "writing vocabulary report"))
+	}
+}
+
+func hasLetter(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPruneVocabulary(t *testing.T) {
+	ed := &edition{Lang: "en", vocabulary: vocabCorpus, dictionary: map[string]bool{"bread": true}}
+	editionsByLang = map[string]*edition{"en": ed}
+	defer func(min int, max float64) { vocabMinDf, vocabMaxDf = min, max }(vocabMinDf, vocabMaxDf)
+	vocabMinDf, vocabMaxDf = 2, 0.75
+	report := filepath.Join(t.TempDir(), "vocab.txt")
+	t.Setenv("VOCAB_REPORT", report)
+
+	// four pages, so the maximum document frequency is 3
+	onPages := map[string][]int{
+		"alpha": {0, 1, 2},    // admitted by document frequency
+		"delta": {0, 1},       // admitted by document frequency
+		"beta":  {0, 1, 2, 3}, // on too many pages
+		"gamma": {0},          // on too few pages
+		"bread": {0, 1},       // admitted, but a dictionary word
+		"42":    {0, 1, 2},    // no letter
+	}
+	wbs := make([]*wikibook, 4)
+	for i := range wbs {
+		wbs[i] = &wikibook{Id: i, Lang: "en", tknQtyMap: make(map[string]int), fieldQtys: make(map[string]fieldQty)}
+	}
+	tokenRefs = make(map[string]map[int]bool)
+	for tkn, ids := range onPages {
+		key := tokenKey("en", tkn)
+		tokenRefs[key] = make(map[int]bool)
+		for _, id := range ids {
+			tokenRefs[key][id] = true
+			wbs[id].tknQtyMap[key] = 1
+		}
+	}
+
+	pruneVocabulary(wbs, false)
+
+	var kept []string
+	for key := range tokenRefs {
+		kept = append(kept, key)
+	}
+	sort.Strings(kept)
+	if want := []string{"en:alpha", "en:bread", "en:delta"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if got := len(wbs[0].tknQtyMap); got != 3 || wbs[0].CountUniqueWords != 3 || !wbs[0].tokensChanged {
+		t.Errorf("page 0 has %d tokens, %d unique words, changed %v after pruning, want 3, 3, true",
+			got, wbs[0].CountUniqueWords, wbs[0].tokensChanged)
+	}
+	b, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "tokens admitted only by document frequency (token, document frequency):\nen:alpha\t3\nen:delta\t2\n"
+	if string(b) != want {
+		t.Errorf("report is\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestPruneVocabularyIncremental(t *testing.T) {
+	ed := &edition{Lang: "en", vocabulary: vocabMerged, dictionary: map[string]bool{"bread": true}}
+	editionsByLang = map[string]*edition{"en": ed}
+	defer func(min int) { vocabMinDf = min }(vocabMinDf)
+	vocabMinDf = 1
+
+	// a single changed page: its new words cannot be judged by their document frequency
+	wb := &wikibook{Id: 0, Lang: "en", tknQtyMap: make(map[string]int), fieldQtys: make(map[string]fieldQty)}
+	tokenRefs = make(map[string]map[int]bool)
+	for _, tkn := range []string{"bread", "kubectl", "teh", "1999"} {
+		key := tokenKey("en", tkn)
+		tokenRefs[key] = map[int]bool{0: true}
+		wb.tknQtyMap[key] = 1
+	}
+	tokenIds = map[string]int{"en:kubectl": 4}
+
+	pruneVocabulary([]*wikibook{wb}, true)
+
+	var kept []string
+	for key := range wb.tknQtyMap {
+		kept = append(kept, key)
+	}
+	sort.Strings(kept)
+	if want := []string{"en:bread", "en:kubectl"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want the dictionary word and the previously admitted token %v", kept, want)
+	}
+	if _, ok := tokenRefs["en:teh"]; ok {
+		t.Error("a new non-dictionary token was admitted by an incremental run")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var writeBatchSize = 500
+
+type (
+	// pageWriter streams wikibook documents and their link edges to mongodb in batches, stages what
+	// the post-read passes need and releases the rest. Only the token counts and hierarchy links stay
+	// in memory; the final sweep fills in the rest.
+	pageWriter struct {
+		coll   *mongo.Collection
+		upsert bool // replace existing documents instead of inserting, for incremental runs
+		batch  []*wikibook
+	}
+)
+
+func init() {
+	if n, err := strconv.Atoi(envOr("ETL_BATCH_SIZE", "")); err == nil && n > 0 {
+		writeBatchSize = n
+	}
+}
+
+func newPageWriter(coll *mongo.Collection, upsert bool) *pageWriter {
+	return &pageWriter{coll: coll, upsert: upsert, batch: make([]*wikibook, 0, writeBatchSize)}
+}
+
+func (w *pageWriter) add(ctx context.Context, wb *wikibook) {
+	w.batch = append(w.batch, wb)
+	if len(w.batch) >= writeBatchSize {
+		w.flush(ctx)
+	}
+}
+
+func (w *pageWriter) flush(ctx context.Context) {
+	if len(w.batch) == 0 {
+		return
+	}
+	if w.upsert {
+		models := make([]mongo.WriteModel, len(w.batch))
+		for i, wb := range w.batch {
+			models[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": wb.Id}).SetReplacement(wb).SetUpsert(true)
+		}
+		bulkWrite(ctx, w.coll, models, "upserting wikibooks")
+	} else {
+		docs := make([]interface{}, len(w.batch))
+		for i, wb := range w.batch {
+			docs[i] = wb
+		}
+		if _, err := w.coll.InsertMany(ctx, docs); err != nil {
+			err = errors.Wrap(err, "inserting many into mongodb")
+			log.Println(err)
+		}
+	}
+	writeLinks(ctx, w.batch, w.upsert)
+	stagePages(ctx, w.batch)
+	for _, wb := range w.batch {
+		wb.release()
+	}
+	w.batch = w.batch[:0]
+}
+
+// release drops the fields that are only needed until the page document is written
+func (wb *wikibook) release() {
+	wb.Abstract = ""
+	wb.BodyText = ""
+	wb.BodyHtml = ""
+	wb.Tokens = nil
+	wb.Links = nil
+	wb.postings, wb.phrasePostings = nil, nil
+	wb.entityQty = nil
+}
+
+// writeTokenDocs inserts the tokens collection in batches
+func writeTokenDocs(ctx context.Context, tkns []string) {
+	for i := 0; i < len(tkns); i += writeBatchSize {
+		j := minInt(i+writeBatchSize, len(tkns))
+		if _, err := tokenColl.InsertMany(ctx, buildTokenDocs(tkns[i:j])); err != nil {
+			err = errors.Wrap(err, "inserting many into mongodb")
+			log.Println(err)
+		}
+	}
+}
+
+// writeTokenVectors builds and writes token_vector documents in batches, filling in each page's TokenRefs
+func writeTokenVectors(ctx context.Context, wbs []*wikibook, upsert bool) {
+	for i := 0; i < len(wbs); i += writeBatchSize {
+		log.Printf("%.2f%%", 100*(float64(i)/float64(len(wbs))))
+		j := minInt(i+writeBatchSize, len(wbs))
+		vectors := tokenVectors(wbs[i:j])
+		if !upsert {
+			if _, err := tokenVectorColl.InsertMany(ctx, vectors); err != nil {
+				err = errors.Wrap(err, "inserting token vector colls")
+				log.Println(err)
+			}
+			continue
+		}
+		models := make([]mongo.WriteModel, len(vectors))
+		for k, wb := range wbs[i:j] {
+			models[k] = mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": wb.Id}).SetReplacement(vectors[k]).SetUpsert(true)
+		}
+		bulkWrite(ctx, tokenVectorColl, models, "upserting token vectors")
+	}
+}
+
+// finalSweep sets the fields that are only known once every page has been read, resolving the
+// link edges of each batch of pages on the way; links are left alone when resolving them failed
+func finalSweep(ctx context.Context, wbs []*wikibook) {
+	models := make([]mongo.WriteModel, 0, writeBatchSize)
+	linksResolved := false
+	for i, wb := range wbs {
+		if i%writeBatchSize == 0 {
+			linksResolved = resolveLinks(ctx, wbs[i:minInt(i+writeBatchSize, len(wbs))])
+		}
+		update := bson.M{"$set": bson.M{
+			"parent_page":    wb.ParentPageId,
+			"child_pages":    wb.ChildPageIds,
+			"count_children": wb.CountChildren,
+			"token_refs":     wb.TokenRefs,
+			"canonical_id":   wb.CanonicalId,
+		}}
+		if linksResolved {
+			// a failed resolution must not overwrite the stored edges
+			update["$set"].(bson.M)["links"] = wb.Links
+		}
+		if len(wb.Entities) > 0 {
+			update["$set"].(bson.M)["entities"] = wb.Entities
+		}
+		if wb.tokensChanged {
+			set := update["$set"].(bson.M)
+			set["tokens"] = wb.tokenQtys()
+			set["count_unique_words"] = wb.CountUniqueWords
+			set["euclidian_norm"] = wb.EuclidianNorm
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": wb.Id}).
+			SetUpdate(update))
+		wb.Links = nil
+		if len(models) == writeBatchSize {
+			bulkWrite(ctx, wbColl, models, "final sweep of wikibooks")
+			models = models[:0]
+		}
+	}
+	bulkWrite(ctx, wbColl, models, "final sweep of wikibooks")
+}
+
+// tokenQtys rebuilds the page's Tokens from tknQtyMap, sorted by token
+func (wb *wikibook) tokenQtys() []tokenQty {
+	tkns := make([]tokenQty, 0, len(wb.tknQtyMap))
+	for key, q := range wb.tknQtyMap {
+		_, tkn := splitTokenKey(key)
+		tkns = append(tkns, tokenQty{Token: tkn, Qty: q, Fields: wb.fieldQtys[key]})
+	}
+	sort.Slice(tkns, func(i, j int) bool { return tkns[i].Token < tkns[j].Token })
+	return tkns
+}
+
+func bulkWrite(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel, what string) {
+	if len(models) == 0 {
+		return
+	}
+	if _, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true)); err != nil {
+		err = errors.Wrap(err, what)
+		log.Println(err)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// xmlDumpSource streams pages out of a MediaWiki pages-articles.xml dump.
+	// Dumps are ordered by page id, so a first pass indexes every article's title and
+	// byte offset and the pages are then read back in url order, matching the sqlite export.
+	xmlDumpSource struct {
+		f       *os.File
+		baseUrl string
+		entries []xmlDumpEntry
+		i       int
+	}
+	xmlDumpEntry struct {
+		url    string
+		offset int64
+	}
+	xmlDumpPage struct {
+		Title    string    `xml:"title"`
+		Ns       int       `xml:"ns"`
+		Redirect *struct{} `xml:"redirect"`
+		Text     string    `xml:"revision>text"`
+	}
+	xmlDumpSiteInfo struct {
+		Base string `xml:"base"`
+	}
+)
+
+var (
+	wikiCommentRe   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	wikiRefRe       = regexp.MustCompile(`(?is)<ref[^>]*/>|<ref[^>]*>.*?</ref>`)
+	wikiDropTagRe   = regexp.MustCompile(`(?is)<(math|gallery|timeline|score|graph)[^>]*>.*?</(math|gallery|timeline|score|graph)>`)
+	wikiTagRe       = regexp.MustCompile(`<[^>]*>`)
+	wikiFileLinkRe  = regexp.MustCompile(`(?i)\[\[\s*(file|image|media|category)\s*:[^\[\]]*(\[\[[^\]]*\]\][^\[\]]*)*\]\]`)
+	wikiLinkRe      = regexp.MustCompile(`\[\[(?:[^|\]]*\|)?([^\]]*)\]\]`)
+	wikiExtLinkRe   = regexp.MustCompile(`\[(?:https?:)?//[^\s\]]+\s*([^\]]*)\]`)
+	wikiHeadingRe   = regexp.MustCompile(`(?m)^=+\s*(.*?)\s*=+\s*$`)
+	wikiEmphasisRe  = regexp.MustCompile(`'{2,}`)
+	wikiListRe      = regexp.MustCompile(`(?m)^[*#:;]+\s*`)
+	wikiMagicWordRe = regexp.MustCompile(`__[A-Z]+__`)
+)
+
+func newXmlDumpSource(path, baseUrl string) (*xmlDumpSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening xml dump")
+	}
+	s := &xmlDumpSource{f: f, baseUrl: baseUrl}
+	if err = s.index(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// index records the url and offset of every main namespace article that is not a redirect
+func (s *xmlDumpSource) index() error {
+	d := xml.NewDecoder(s.f)
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "indexing xml dump")
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "siteinfo":
+			var si xmlDumpSiteInfo
+			if err = d.DecodeElement(&si, &start); err != nil {
+				return errors.Wrap(err, "decoding siteinfo")
+			}
+			if i := strings.LastIndex(si.Base, "/wiki/"); i >= 0 && si.Base[:i+len("/wiki/")] != s.baseUrl {
+				log.Printf("xml dump is from %s but the edition base is %s, using the edition base", si.Base[:i+len("/wiki/")], s.baseUrl)
+			}
+		case "page":
+			var p xmlDumpPage
+			if err = d.DecodeElement(&p, &start); err != nil {
+				return errors.Wrap(err, "decoding page")
+			}
+			if p.Ns != 0 || p.Redirect != nil {
+				continue
+			}
+			s.entries = append(s.entries, xmlDumpEntry{url: s.pageUrl(p.Title), offset: offset})
+		}
+	}
+	sort.SliceStable(s.entries, func(i, j int) bool { return s.entries[i].url < s.entries[j].url })
+	return nil
+}
+
+func (s *xmlDumpSource) pageUrl(title string) string {
+	return s.baseUrl + strings.ReplaceAll(strings.TrimSpace(title), " ", "_")
+}
+
+func (s *xmlDumpSource) Next() (sourceRecord, error) {
+	var rec sourceRecord
+	if s.i >= len(s.entries) {
+		return rec, io.EOF
+	}
+	e := s.entries[s.i]
+	s.i++
+
+	if _, err := s.f.Seek(e.offset, io.SeekStart); err != nil {
+		return rec, errors.Wrap(err, "seeking to page")
+	}
+	var p xmlDumpPage
+	if err := xml.NewDecoder(s.f).Decode(&p); err != nil {
+		return rec, newRowError(e.url, errors.Wrap(err, "decoding page"))
+	}
+
+	rec.Title = p.Title
+	rec.Url = e.url
+	rec.BodyText = stripWikitext(p.Text)
+	rec.Abstract = firstParagraph(rec.BodyText)
+	return rec, nil
+}
+
+func (s *xmlDumpSource) Close() error {
+	return s.f.Close()
+}
+
+// stripWikitext reduces wikitext markup to its readable text
+func stripWikitext(s string) string {
+	s = wikiCommentRe.ReplaceAllString(s, "")
+	s = wikiRefRe.ReplaceAllString(s, "")
+	s = wikiDropTagRe.ReplaceAllString(s, "")
+	s = stripNested(s, "{{", "}}")
+	s = stripTableMarkup(s)
+	s = wikiFileLinkRe.ReplaceAllString(s, "")
+	s = wikiLinkRe.ReplaceAllString(s, "$1")
+	s = wikiExtLinkRe.ReplaceAllString(s, "$1")
+	s = wikiTagRe.ReplaceAllString(s, "")
+	s = wikiHeadingRe.ReplaceAllString(s, "$1")
+	s = wikiEmphasisRe.ReplaceAllString(s, "")
+	s = wikiListRe.ReplaceAllString(s, "")
+	s = wikiMagicWordRe.ReplaceAllString(s, "")
+	return html.UnescapeString(s)
+}
+
+// stripNested removes balanced, possibly nested, open/close delimited spans such as templates
+func stripNested(s, open, close string) string {
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], open):
+			depth++
+			i += len(open)
+		case depth > 0 && strings.HasPrefix(s[i:], close):
+			depth--
+			i += len(close)
+		default:
+			if depth == 0 {
+				b.WriteByte(s[i])
+			}
+			i++
+		}
+	}
+	return b.String()
+}
+
+// stripTableMarkup keeps the cell text of wikitext tables and drops the table syntax
+func stripTableMarkup(s string) string {
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	for _, l := range lines {
+		t := strings.TrimSpace(l)
+		switch {
+		case strings.HasPrefix(t, "{|"), strings.HasPrefix(t, "|}"), strings.HasPrefix(t, "|-"):
+			continue
+		case strings.HasPrefix(t, "|+"):
+			out = append(out, tableCellText(t[2:]))
+		case strings.HasPrefix(t, "|"), strings.HasPrefix(t, "!"):
+			sep := "||"
+			if t[0] == '!' {
+				sep = "!!"
+			}
+			for _, c := range strings.Split(t[1:], sep) {
+				out = append(out, tableCellText(c))
+			}
+		default:
+			out = append(out, l)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// tableCellText drops a leading attribute section such as `style="..." | text`
+func tableCellText(c string) string {
+	if i := strings.Index(c, "|"); i >= 0 && !strings.Contains(c[:i], "[[") {
+		c = c[i+1:]
+	}
+	return strings.TrimSpace(c)
+}
+
+func firstParagraph(s string) string {
+	for _, p := range strings.Split(s, "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			return p
+		}
+	}
+	return ""
+}
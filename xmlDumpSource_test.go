@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestStripWikitext(t *testing.T) {
+	tests := []struct {
+		wikitext, want string
+	}{
+		{"'''Bread''' is made from [[flour]] and [[Water|water]].<!-- note --><ref>Smith 2001</ref>",
+			"Bread is made from flour and water."},
+		{"{{Infobox|name={{nested|x}}}}Intro text.", "Intro text."},
+		{"== Ingredients ==\n* 500 g flour\n# knead well", "Ingredients\n500 g flour\nknead well"},
+		{"See [http://example.com the site] and [[File:Loaf.jpg|thumb|A loaf]] here.", "See the site and  here."},
+		{"{|\n|+ Caption\n! A !! B\n|-\n| 1 || 2\n|}", "Caption\nA\nB\n1\n2"},
+		{"salt &amp; pepper", "salt & pepper"},
+	}
+	for _, tt := range tests {
+		if got := stripWikitext(tt.wikitext); got != tt.want {
+			t.Errorf("stripWikitext(%q) = %q, want %q", tt.wikitext, got, tt.want)
+		}
+	}
+}